@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// aliasRule sets the tag of lines matching a regular expression.
+type aliasRule struct {
+	Regex string `toml:"regex"`
+	Tag   string `toml:"tag"`
+}
+
+type compiledAlias struct {
+	regex *regexp.Regexp
+	tag   string
+}
+
+// aliases picks the tag of a line from the first matching alias.
+type aliases []compiledAlias
+
+// newAliases compiles the [[pipe.alias]] tables of a pipe.
+func newAliases(p pipe) (aliases, error) {
+	var a aliases
+
+	for _, rule := range p.Alias {
+		if rule.Regex == "" {
+			return nil, fmt.Errorf("%s has an alias without a regex", p.source())
+		}
+
+		if rule.Tag == "" {
+			return nil, fmt.Errorf("%s has an alias without a tag", p.source())
+		}
+
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("%s has invalid alias regex: %s", p.source(), err.Error())
+		}
+
+		a = append(a, compiledAlias{regex: regex, tag: rule.Tag})
+	}
+
+	return a, nil
+}
+
+// tag returns the tag for line, or def if no alias matches.
+func (a aliases) tag(line string, def string) string {
+	for _, alias := range a {
+		if alias.regex.MatchString(line) {
+			return alias.tag
+		}
+	}
+
+	return def
+}
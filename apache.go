@@ -0,0 +1,50 @@
+package main
+
+import "regexp"
+
+// apacheCombined matches a line in the Combined Log Format of Apache and
+// nginx:
+//
+//	127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326 "http://example.com/" "Mozilla/4.08"
+var apacheCombined = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "(\S+) (\S+) (\S+)" (\d{3}) (\d+|-) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)"\s*$`)
+
+// apacheFields are the names of the fields matched by apacheCombined, in
+// order. They're named like the nginx variables.
+var apacheFields = []string{"remote_addr", "remote_user", "time_local", "method", "uri", "protocol", "status", "bytes_sent", "referer", "user_agent"}
+
+// apacheParser extracts fields from access log lines in the Combined Log
+// Format.
+type apacheParser struct{}
+
+// newApacheParser returns the parser of a pipe, or nil if
+// parse_apache_combined is not set.
+func newApacheParser(p pipe) *apacheParser {
+	if !p.ParseApacheCombined {
+		return nil
+	}
+
+	return &apacheParser{}
+}
+
+// parse returns the fields of a line. It returns false for lines not in the
+// Combined Log Format.
+func (a *apacheParser) parse(line string) (map[string]string, bool) {
+	if a == nil {
+		return nil, false
+	}
+
+	match := apacheCombined.FindStringSubmatch(line)
+	if match == nil {
+		return nil, false
+	}
+
+	fields := make(map[string]string, len(apacheFields))
+	for i, name := range apacheFields {
+		// An unknown user or size is "-"
+		if match[i+1] != "-" {
+			fields[name] = match[i+1]
+		}
+	}
+
+	return fields, true
+}
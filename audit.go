@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/syslog"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAuditFacility = "auth"
+	defaultAuditSeverity = "notice"
+	defaultAuditTag      = "logpipe-audit"
+)
+
+// auditConfig configures the audit log, which records the configuration
+// logpipe runs with separately from its own messages. It's disabled
+// without an output.
+type auditConfig struct {
+	Output   string `toml:"output"`
+	Facility string `toml:"facility"`
+	Severity string `toml:"severity"`
+	Tag      string `toml:"tag"`
+}
+
+// configFile identifies a version of the configuration file.
+type configFile struct {
+	modified time.Time
+	hash     string
+}
+
+// fields returns the modification time and hash as fields of an audit
+// record, or "-" for both if the file couldn't be read.
+func (f configFile) fields(prefix string) string {
+	if f.hash == "" {
+		return fmt.Sprintf("%s_mtime=- %s_sha256=-", prefix, prefix)
+	}
+
+	return fmt.Sprintf("%s_mtime=%s %s_sha256=%s", prefix, f.modified.UTC().Format(time.RFC3339), prefix, f.hash)
+}
+
+// readConfigFile returns the modification time and hash of the file at
+// path.
+func readConfigFile(path string) configFile {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return configFile{}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return configFile{}
+	}
+
+	hash := sha256.Sum256(content)
+
+	return configFile{
+		modified: fileInfo.ModTime(),
+		hash:     hex.EncodeToString(hash[:]),
+	}
+}
+
+// checkAudit validates the [audit] section.
+func checkAudit(c auditConfig) []error {
+	var errs []error
+
+	switch c.Output {
+	case "", loggingSyslog:
+	default:
+		errs = append(errs, fmt.Errorf("[audit] has unknown output (%s)", c.Output))
+	}
+
+	if _, found := facilities[c.Facility]; c.Facility != "" && !found {
+		errs = append(errs, fmt.Errorf("[audit] has unknown facility (%s)", c.Facility))
+	}
+
+	if _, found := severities[c.Severity]; c.Severity != "" && !found {
+		errs = append(errs, fmt.Errorf("[audit] has unknown severity (%s)", c.Severity))
+	}
+
+	return errs
+}
+
+// diffPipes returns the sources of the pipes added, removed and modified
+// from previous to current.
+func diffPipes(previous []pipe, current []pipe) ([]string, []string, []string) {
+	var added, removed, modified []string
+
+	old := make(map[string]pipe)
+	for _, p := range previous {
+		old[p.source()] = p
+	}
+
+	for _, p := range current {
+		o, found := old[p.source()]
+		switch {
+		case !found:
+			added = append(added, p.source())
+		case !reflect.DeepEqual(o, p):
+			modified = append(modified, p.source())
+		}
+		delete(old, p.source())
+	}
+
+	for source := range old {
+		removed = append(removed, source)
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(modified)
+
+	return added, removed, modified
+}
+
+// audit writes an audit record of event, like "startup" or "reload", to
+// the audit log of current. previous are the pipes before, read from
+// previousFile, and currentFile is the configuration file read now.
+func audit(event string, path string, previous []pipe, current config, previousFile configFile, currentFile configFile) {
+	c := current.Audit
+	if c.Output == "" {
+		return
+	}
+
+	facility := c.Facility
+	if facility == "" {
+		facility = defaultAuditFacility
+	}
+
+	severity := c.Severity
+	if severity == "" {
+		severity = defaultAuditSeverity
+	}
+
+	tag := c.Tag
+	if tag == "" {
+		tag = defaultAuditTag
+	}
+
+	added, removed, modified := diffPipes(previous, current.Pipe)
+
+	record := fmt.Sprintf("event=%s config=%s %s %s euid=%d added=%s removed=%s modified=%s",
+		event, path, previousFile.fields("previous"), currentFile.fields("current"), os.Geteuid(),
+		strings.Join(added, ","), strings.Join(removed, ","), strings.Join(modified, ","))
+
+	writer, err := syslog.New(facilities[facility]|severities[severity], tag)
+	if err != nil {
+		logError("Writing audit record failed: %s", err.Error())
+		return
+	}
+	defer writer.Close()
+
+	if _, err := writer.Write([]byte(record)); err != nil {
+		logError("Writing audit record failed: %s", err.Error())
+	}
+}
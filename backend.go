@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"syscall"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	backendFile   = "file"
+	backendConsul = "consul"
+	backendEtcd   = "etcd"
+)
+
+// configStore is a remote store holding the configuration.
+type configStore interface {
+	// read returns the stored configuration
+	read() ([]byte, error)
+
+	// watch calls changed whenever the stored configuration changes. It
+	// never returns
+	watch(changed func())
+}
+
+// newConfigStore returns the store of the config_backend of c, or nil if
+// the configuration is read from the file.
+func newConfigStore(c config) (configStore, error) {
+	switch c.ConfigBackend {
+	case backendConsul:
+		return newConsulClient(c), nil
+	case backendEtcd:
+		return newEtcdClient(c)
+	}
+
+	return nil, nil
+}
+
+// setsBackend returns true if any setting of the config_backend is set.
+func (c config) setsBackend() bool {
+	return c.ConfigBackend != "" || c.ConsulAddress != "" || c.ConsulKey != "" ||
+		len(c.EtcdEndpoints) > 0 || c.EtcdKey != "" || c.EtcdTLSCert != "" || c.EtcdTLSKey != "" || c.EtcdTLSCA != ""
+}
+
+// sameBackend returns true if c and other read the configuration from the
+// same place.
+func (c config) sameBackend(other config) bool {
+	return c.ConfigBackend == other.ConfigBackend &&
+		c.ConsulAddress == other.ConsulAddress && c.ConsulKey == other.ConsulKey &&
+		slices.Equal(c.EtcdEndpoints, other.EtcdEndpoints) && c.EtcdKey == other.EtcdKey &&
+		c.EtcdTLSCert == other.EtcdTLSCert && c.EtcdTLSKey == other.EtcdTLSKey && c.EtcdTLSCA == other.EtcdTLSCA
+}
+
+// checkConfigBackend validates the config_backend settings of the file.
+func checkConfigBackend(c config) []error {
+	var errs []error
+
+	switch c.ConfigBackend {
+	case "", backendFile, backendConsul, backendEtcd:
+	default:
+		errs = append(errs, fmt.Errorf("unknown config_backend (%s)", c.ConfigBackend))
+	}
+
+	if c.ConfigBackend != backendConsul && (c.ConsulAddress != "" || c.ConsulKey != "") {
+		errs = append(errs, errors.New("consul_address and consul_key need config_backend \"consul\""))
+	}
+
+	if c.ConfigBackend != backendEtcd && (len(c.EtcdEndpoints) > 0 || c.EtcdKey != "" || c.EtcdTLSCert != "" || c.EtcdTLSKey != "" || c.EtcdTLSCA != "") {
+		errs = append(errs, errors.New("etcd_endpoints, etcd_key and the etcd TLS settings need config_backend \"etcd\""))
+	}
+	if (c.EtcdTLSCert == "") != (c.EtcdTLSKey == "") {
+		errs = append(errs, errors.New("etcd_tls_cert and etcd_tls_key must be set together"))
+	}
+
+	return errs
+}
+
+// decodeBackend decodes the configuration held by the store the backend
+// settings of file point to. The settings of the file are replaced,
+// except for the backend settings themselves.
+func decodeBackend(file config) (config, []string, error) {
+	var c config
+
+	store, err := newConfigStore(file)
+	if err != nil {
+		return c, nil, err
+	}
+
+	value, err := store.read()
+	if err != nil {
+		return c, nil, err
+	}
+
+	meta, err := toml.Decode(string(value), &c)
+	if err != nil {
+		return c, nil, fmt.Errorf("configuration in %s: %w", file.ConfigBackend, err)
+	}
+
+	if len(c.Include) > 0 {
+		return c, nil, fmt.Errorf("include can't be used in configuration from %s", file.ConfigBackend)
+	}
+	if c.setsBackend() {
+		return c, nil, errors.New("config_backend and its settings can only be set in the configuration file")
+	}
+
+	for i := range c.Pipe {
+		if err := decodeOutputs(meta, &c.Pipe[i]); err != nil {
+			return c, nil, err
+		}
+	}
+
+	var undecoded []string
+	for _, key := range meta.Undecoded() {
+		undecoded = append(undecoded, file.ConfigBackend+": "+key.String())
+	}
+
+	c.ConfigBackend = file.ConfigBackend
+	c.ConsulAddress = file.ConsulAddress
+	c.ConsulKey = file.ConsulKey
+	c.EtcdEndpoints = file.EtcdEndpoints
+	c.EtcdKey = file.EtcdKey
+	c.EtcdTLSCert = file.EtcdTLSCert
+	c.EtcdTLSKey = file.EtcdTLSKey
+	c.EtcdTLSCA = file.EtcdTLSCA
+
+	return c, undecoded, nil
+}
+
+// watchBackend reloads the configuration like after SIGHUP whenever it
+// changes in the store of c.
+func watchBackend(c config) {
+	store, err := newConfigStore(c)
+	if err != nil || store == nil {
+		return
+	}
+
+	go store.watch(func() {
+		logInfo("Configuration in %s changed, reloading", c.ConfigBackend)
+		syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+	})
+}
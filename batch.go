@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	defaultBatchSize    = 100
+	defaultBatchTimeout = time.Second
+)
+
+// batchEntry is a single message in a batch.
+type batchEntry struct {
+	time    time.Time
+	message string
+	fields  map[string]string
+}
+
+// batcher collects messages and sends them in batches from a separate
+// goroutine. A batch is sent when it's full, or when the oldest message in
+// it is older than the timeout. Failed batches are retried with exponential
+// backoff. Messages of a batch still failing when closing are passed to
+// drop.
+type batcher struct {
+	name    string
+	size    int
+	timeout time.Duration
+	send    func(batch []batchEntry) error
+	drop    dropFunc
+
+	queue chan batchEntry
+	done  chan struct{}
+
+	// ctx is cancelled when closing to stop retrying
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newBatcher(name string, size int, timeout time.Duration, send func(batch []batchEntry) error, drop dropFunc) *batcher {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+
+	if timeout <= 0 {
+		timeout = defaultBatchTimeout
+	}
+
+	b := &batcher{
+		name:    name,
+		size:    size,
+		timeout: timeout,
+		send:    send,
+		drop:    drop,
+		queue:   make(chan batchEntry, size),
+		done:    make(chan struct{}),
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+
+	go b.run()
+
+	return b
+}
+
+// Write queues a message. It blocks if the queue is full.
+func (b *batcher) Write(p []byte) (int, error) {
+	b.enqueue(string(p), nil)
+
+	return len(p), nil
+}
+
+// enqueue queues a message with its fields, for outputs sending fields.
+func (b *batcher) enqueue(message string, fields map[string]string) {
+	b.queue <- batchEntry{time: time.Now(), message: message, fields: fields}
+}
+
+// Close sends the queued messages. Failed batches are not retried while
+// closing.
+func (b *batcher) Close() error {
+	close(b.queue)
+	b.cancel()
+	<-b.done
+
+	return nil
+}
+
+func (b *batcher) run() {
+	defer close(b.done)
+
+	timer := time.NewTimer(b.timeout)
+	timer.Stop()
+
+	var batch []batchEntry
+	for {
+		select {
+		case entry, ok := <-b.queue:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+
+			if len(batch) == 0 {
+				timer.Reset(b.timeout)
+			}
+
+			batch = append(batch, entry)
+			if len(batch) >= b.size {
+				timer.Stop()
+				b.flush(batch)
+				batch = nil
+			}
+
+		case <-timer.C:
+			b.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+// flush sends a batch, retrying until it succeeds or the batcher is closed.
+func (b *batcher) flush(batch []batchEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	backoff := minBackoff
+	for {
+		err := b.send(batch)
+		if err == nil {
+			return
+		}
+
+		if b.ctx.Err() != nil {
+			logError("Sending to %s failed, dropping %d messages: %s", b.name, len(batch), err.Error())
+			for _, entry := range batch {
+				b.drop(entry.message, entry.fields, err)
+			}
+			return
+		}
+
+		logWarning("Sending to %s failed, retrying in %s: %s", b.name, backoff, err.Error())
+		backoff = sleepBackoff(b.ctx, backoff)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// BenchmarkPipeToSyslog measures lines read from a named pipe, formatted
+// as syslog messages and written to a syslog server that discards them.
+func BenchmarkPipeToSyslog(b *testing.B) {
+	for _, size := range []int{256, 4096, 16384, 65536} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			benchmarkPipeToSyslog(b, size, 2*size)
+		})
+	}
+}
+
+// BenchmarkBufferSize compares lines read with buffer_size just large
+// enough and with a larger buffer_size.
+func BenchmarkBufferSize(b *testing.B) {
+	for _, size := range []int{512, 4096} {
+		for _, bufferSize := range []int{size, 16 * size} {
+			b.Run(fmt.Sprintf("line=%d/buffer_size=%d", size, bufferSize), func(b *testing.B) {
+				benchmarkPipeToSyslog(b, size, bufferSize)
+			})
+		}
+	}
+}
+
+// benchmarkPipeToSyslog writes b.N lines of size bytes, including the
+// newline, to a pipe with bufferSize.
+func benchmarkPipeToSyslog(b *testing.B, size int, bufferSize int) {
+	p := pipe{
+		Path:       filepath.Join(b.TempDir(), "bench_log"),
+		Tag:        "bench",
+		BufferSize: bufferSize,
+	}
+
+	if err := syscall.Mkfifo(p.Path, 0600); err != nil {
+		b.Fatal(err)
+	}
+
+	// Opening the read end first keeps opening the write end from blocking
+	reader, err := os.OpenFile(p.Path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer reader.Close()
+
+	writer, err := os.OpenFile(p.Path, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	formatter := newSyslogFormatter(p, syslog.LOG_LOCAL6|syslog.LOG_INFO)
+	lines := 0
+	handle := func(line string) {
+		io.WriteString(io.Discard, formatter.frame(formatter.formatMessage(line, nil)))
+		lines++
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- readPipe(context.Background(), reader, p.bufferSize(), p.splitLines(), handle)
+	}()
+
+	line := []byte(strings.Repeat("x", size-1) + "\n")
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := writer.Write(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	writer.Close()
+	if err := <-done; err != nil {
+		b.Fatal(err)
+	}
+
+	b.StopTimer()
+
+	if lines != b.N {
+		b.Fatalf("read %d lines, wrote %d", lines, b.N)
+	}
+}
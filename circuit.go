@@ -0,0 +1,116 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+
+	defaultOpenDuration = 60 * time.Second
+)
+
+// circuitBreaker stops connection attempts to an output that keeps
+// failing. After threshold consecutive failed connections or writes the
+// breaker opens, and no attempt is made for openDuration. Then it's
+// half-open and allows one attempt: if a message gets through, the breaker
+// closes again, otherwise it opens for another openDuration. A nil breaker
+// never opens.
+//
+// It's only used by the goroutine of a reconnectWriter, so it needs no
+// locking.
+type circuitBreaker struct {
+	name         string
+	threshold    int
+	openDuration time.Duration
+	stats        *pipeStats
+
+	state    int
+	failures int
+	opened   time.Time
+}
+
+// newCircuitBreaker returns the breaker for the output of a pipe, or nil if
+// the pipe has no failure_threshold.
+func newCircuitBreaker(p pipe) *circuitBreaker {
+	if p.FailureThreshold == 0 {
+		return nil
+	}
+
+	openDuration := p.OpenDuration
+	if openDuration <= 0 {
+		openDuration = defaultOpenDuration
+	}
+
+	return &circuitBreaker{
+		name:         p.destination(),
+		threshold:    p.FailureThreshold,
+		openDuration: openDuration,
+		stats:        statsFor(p.source()),
+	}
+}
+
+// wait returns how long to wait before the next attempt, 0 if it's
+// allowed now. An open breaker turns half-open when its time is up.
+func (b *circuitBreaker) wait() time.Duration {
+	if b == nil || b.state != breakerOpen {
+		return 0
+	}
+
+	remaining := b.openDuration - time.Since(b.opened)
+	if remaining > 0 {
+		return remaining
+	}
+
+	logInfo("Circuit breaker for %s is half-open, trying again", b.name)
+	b.state = breakerHalfOpen
+
+	return 0
+}
+
+// success records a message written to the output, closing the breaker.
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+
+	if b.state != breakerClosed {
+		logInfo("Circuit breaker for %s closed", b.name)
+		b.stats.breakersOpen.Add(-1)
+	}
+
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// failure records a failed connection or write. It opens the breaker when
+// the threshold is reached, or if the attempt of a half-open breaker
+// failed.
+func (b *circuitBreaker) failure() {
+	if b == nil {
+		return
+	}
+
+	b.failures++
+	if b.state == breakerClosed && b.failures < b.threshold {
+		return
+	}
+
+	if b.state == breakerClosed {
+		b.stats.breakersOpen.Add(1)
+		b.stats.breakerTrips.Add(1)
+	}
+
+	logWarning("Circuit breaker for %s opened after %d failures, not trying again for %s", b.name, b.failures, b.openDuration)
+	b.state = breakerOpen
+	b.opened = time.Now()
+}
+
+// close releases the breaker when its writer is closed.
+func (b *circuitBreaker) close() {
+	if b != nil && b.state != breakerClosed {
+		b.stats.breakersOpen.Add(-1)
+	}
+}
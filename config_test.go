@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// FuzzConfigParse checks that no configuration file makes decoding or
+// validation panic.
+func FuzzConfigParse(f *testing.F) {
+	example, err := os.ReadFile("example.conf")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(example)
+	f.Add([]byte(""))
+	f.Add([]byte(`
+[[pipe]]
+path = "/tmp/access_log"
+facility = "local6"
+severity = "info"
+tag = "nginx"
+`))
+	f.Add([]byte(`
+[syslog]
+facility = "local6"
+severity = "info"
+
+[labels]
+env = "production"
+
+[[pipe]]
+path = "/tmp/app_log"
+tag_template = "{{.Basename}}"
+format = "rfc5424"
+parse_json = true
+
+[[pipe.output]]
+type = "gelf"
+address = "localhost:12201"
+
+[[pipe.output]]
+type = "jsonlines"
+output_path = "-"
+
+[[pipe.severity_map]]
+regex = "ERROR"
+severity = "err"
+
+[[pipe.rewrite]]
+regex = "password=\\S+"
+with = "password=***"
+`))
+	f.Add([]byte("[[pipe]\npath = "))
+	f.Add([]byte("[[pipe]]\noutput = 42\n"))
+	f.Add([]byte("[[pipe]]\noutput = [1, 2]\n"))
+	f.Add([]byte("[[pipe]]\nseverity_map = \"x\"\n[[pipe.output]]\n"))
+	f.Add([]byte("pipe = 1\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		path := filepath.Join(t.TempDir(), "logpipe.conf")
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		// Other backends would read the configuration over the network
+		config, _, err := decodeFile(path, nil)
+		if err == nil && config.ConfigBackend != "" && config.ConfigBackend != backendFile {
+			checkConfig(config)
+			return
+		}
+
+		readConfig(path)
+	})
+}
+
+func TestCheckPipe(t *testing.T) {
+	base := pipe{Path: "/tmp/app_log", Facility: "local6", Severity: "info", Tag: "app"}
+
+	tests := []struct {
+		name   string
+		modify func(p *pipe)
+		err    string
+	}{
+		{"valid", func(p *pipe) {}, ""},
+		{"no input", func(p *pipe) { p.Path = "" }, "must have exactly one of path"},
+		{"two inputs", func(p *pipe) { p.ListenTCP = ":5140" }, "must have exactly one of path"},
+		{"no facility", func(p *pipe) { p.Facility = "" }, "has no facility set"},
+		{"unknown facility", func(p *pipe) { p.Facility = "local9" }, "unknown facility (local9)"},
+		{"unknown severity", func(p *pipe) { p.Severity = "loud" }, "unknown severity (loud)"},
+		{"network without address", func(p *pipe) { p.Network = "tcp" }, "both network and address"},
+		{"remote syslog", func(p *pipe) { p.Network, p.Address = "tcp", "loghost:514" }, ""},
+		{"octet-count over udp", func(p *pipe) {
+			p.Network, p.Address, p.TCPFraming = "udp", "loghost:514", framingOctetCount
+		}, "tcp_framing \"octet-count\" with network \"tcp\""},
+		{"unknown tcp_framing", func(p *pipe) { p.TCPFraming = "length" }, "unknown tcp_framing (length)"},
+		{"negative buffer_size", func(p *pipe) { p.BufferSize = -1 }, "negative buffer_size (-1)"},
+		{"negative max_message_size", func(p *pipe) { p.MaxMessageSize = -1 }, "negative max_message_size (-1)"},
+		{"open_duration without failure_threshold", func(p *pipe) { p.OpenDuration = time.Second }, "open_duration without failure_threshold"},
+		{"overflow_policy without queue_depth", func(p *pipe) { p.OverflowPolicy = overflowDropOldest }, "overflow_policy without queue_depth"},
+		{"relative syslog socket", func(p *pipe) { p.SyslogSockets = []string{"dev/log"} }, "not an absolute path (dev/log)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := base
+			test.modify(&p)
+
+			err := errors.Join(checkPipe(p)...)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConsulAddress = "127.0.0.1:8500"
+	defaultConsulKey     = "logpipe/config"
+
+	// consulWait is how long a watch waits for the key to change before
+	// asking again
+	consulWait = 5 * time.Minute
+
+	// consulRetry is how long to wait after a failed watch
+	consulRetry = 5 * time.Second
+)
+
+// consulClient reads the configuration from a key in Consul KV through the
+// HTTP API.
+type consulClient struct {
+	client *http.Client
+	url    string
+}
+
+func newConsulClient(c config) *consulClient {
+	address := c.ConsulAddress
+	if address == "" {
+		address = defaultConsulAddress
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	key := c.ConsulKey
+	if key == "" {
+		key = defaultConsulKey
+	}
+
+	return &consulClient{
+		// Blocking queries take up to consulWait, plus the jitter Consul
+		// adds
+		client: &http.Client{Timeout: consulWait + time.Minute},
+		url:    strings.TrimSuffix(address, "/") + "/v1/kv/" + strings.TrimPrefix(key, "/"),
+	}
+}
+
+// get returns the value of the key and its modify index. With a non-zero
+// index, it blocks until the key changes or Consul's wait time passes.
+func (c *consulClient) get(index uint64) ([]byte, uint64, error) {
+	query := url.Values{"raw": {""}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", consulWait.String())
+	}
+
+	response, err := c.client.Get(c.url + "?" + query.Encode())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, 0, fmt.Errorf("%s not found in Consul", c.url)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("reading %s from Consul failed: %s", c.url, response.Status)
+	}
+
+	value, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	newIndex, _ := strconv.ParseUint(response.Header.Get("X-Consul-Index"), 10, 64)
+
+	return value, newIndex, nil
+}
+
+// read returns the configuration stored in Consul.
+func (c *consulClient) read() ([]byte, error) {
+	value, _, err := c.get(0)
+	return value, err
+}
+
+// watch calls changed when the modify index of the key changes.
+func (c *consulClient) watch(changed func()) {
+	var index uint64
+	for {
+		_, newIndex, err := c.get(index)
+		if err != nil {
+			logWarning("Watching configuration in Consul failed: %s", err.Error())
+			time.Sleep(consulRetry)
+			continue
+		}
+
+		// Consul may return early or with a smaller index after its
+		// state was reset. Only a changed index means a changed key
+		if index > 0 && newIndex != index {
+			changed()
+		}
+
+		if newIndex < index {
+			newIndex = 0
+		}
+		index = newIndex
+
+		// Without X-Consul-Index the next query can't block, so we poll
+		// instead of asking again right away
+		if index == 0 {
+			time.Sleep(consulRetry)
+		}
+	}
+}
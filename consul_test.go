@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A server not sending X-Consul-Index answers every query right away, so
+// the watch must not ask again without waiting.
+func TestConsulWatchWithoutIndex(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("[[pipe]]\n"))
+	}))
+	defer server.Close()
+
+	c := newConsulClient(config{ConsulAddress: server.URL})
+	go c.watch(func() {})
+
+	time.Sleep(500 * time.Millisecond)
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests within 500ms, want 1", n)
+	}
+}
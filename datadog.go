@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log/syslog"
+	"net/http"
+	"strings"
+)
+
+// defaultDatadogSite is the Datadog site used if datadog_site isn't set.
+const defaultDatadogSite = "datadoghq.com"
+
+// datadogLog is a single log sent to the Datadog Logs API. The timestamp
+// is in milliseconds, so retried batches keep the time the message was
+// read.
+type datadogLog struct {
+	Source    string `json:"ddsource"`
+	Tags      string `json:"ddtags"`
+	Hostname  string `json:"hostname"`
+	Service   string `json:"service,omitempty"`
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// datadogWriter sends messages in batches to the Datadog Logs API.
+type datadogWriter struct {
+	*batcher
+	client   *http.Client
+	url      string
+	apiKey   string
+	hostname string
+	service  string
+	tags     string
+}
+
+func newDatadogWriter(p pipe, priority syslog.Priority, tlsConfig *tls.Config) *datadogWriter {
+	w := &datadogWriter{
+		client:   newHTTPClient(tlsConfig),
+		url:      p.datadogURL(),
+		apiKey:   p.DatadogAPIKey,
+		hostname: p.hostname(),
+		service:  p.Tag,
+		tags:     "facility:" + facilityName(priority) + ",severity:" + severityName(priority) + ",pipe:" + p.source(),
+	}
+	w.batcher = newBatcher("Datadog", p.BatchSize, p.BatchTimeout, w.send, deadLetterDrop(p, priority))
+
+	return w
+}
+
+// datadogURL returns the intake URL of the Datadog site of a pipe. The
+// address replaces it, for example to go through a proxy.
+func (p pipe) datadogURL() string {
+	if p.Address != "" {
+		return strings.TrimSuffix(p.Address, "/") + "/api/v2/logs"
+	}
+
+	site := p.DatadogSite
+	if site == "" {
+		site = defaultDatadogSite
+	}
+
+	return "https://http-intake.logs." + site + "/api/v2/logs"
+}
+
+// send posts a batch of logs as a JSON array. The API key only goes into
+// the request header, errors never include it.
+func (w *datadogWriter) send(batch []batchEntry) error {
+	logs := make([]datadogLog, 0, len(batch))
+	for _, entry := range batch {
+		logs = append(logs, datadogLog{
+			Source:    "logpipe",
+			Tags:      w.tags,
+			Hostname:  w.hostname,
+			Service:   w.service,
+			Message:   strings.TrimSuffix(entry.message, "\n"),
+			Timestamp: entry.time.UnixMilli(),
+		})
+	}
+
+	body, err := json.Marshal(logs)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("DD-API-KEY", w.apiKey)
+
+	return postHTTP(w.client, w.url, "application/json", header, body)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultDeadLetterMode is the mode of the dead letter pipe. Failed
+	// messages can be as sensitive as any other
+	defaultDeadLetterMode = 0600
+
+	// deadLetterTimeout is how long a write to the dead letter pipe can
+	// wait for a reader to make room
+	deadLetterTimeout = 100 * time.Millisecond
+)
+
+// deadLetterRecord is a message that couldn't be delivered, written to the
+// dead letter pipe as a JSON line.
+type deadLetterRecord struct {
+	jsonRecord
+	Destination string `json:"destination"`
+	Error       string `json:"error"`
+}
+
+// deadLetterQueue is a named pipe receiving messages that couldn't be
+// delivered. It's opened for reading and writing, so messages are kept in
+// the pipe's buffer until a reader comes along, and writing never fails
+// because there's no reader.
+type deadLetterQueue struct {
+	path string
+
+	lock sync.Mutex
+	fd   *os.File
+}
+
+// deadLetter is the dead letter pipe of all pipes. It's nil without
+// dead_letter_path.
+var deadLetter atomic.Pointer[deadLetterQueue]
+
+// setDeadLetter creates and opens the dead letter pipe at path. The pipe
+// is kept if path didn't change.
+func setDeadLetter(path string) error {
+	current := deadLetter.Load()
+	if current != nil && current.path == path {
+		return nil
+	}
+
+	if path == "" {
+		deadLetter.Store(nil)
+	} else {
+		if err := createFifo(path, defaultDeadLetterMode, -1, -1); err != nil {
+			return fmt.Errorf("creating dead letter pipe failed: %w", err)
+		}
+
+		fd, err := os.OpenFile(path, os.O_RDWR|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			return fmt.Errorf("opening dead letter pipe failed: %w", err)
+		}
+
+		deadLetter.Store(&deadLetterQueue{path: path, fd: fd})
+	}
+
+	// Writers may still hold the old pipe, closing it just fails their
+	// write
+	if current != nil {
+		current.lock.Lock()
+		current.fd.Close()
+		current.lock.Unlock()
+	}
+
+	return nil
+}
+
+// writeDeadLetter writes a message that couldn't be delivered by p to the
+// dead letter pipe, if there is one.
+func writeDeadLetter(p pipe, message string, priority syslog.Priority, tag string, fields map[string]string, cause error) {
+	dlq := deadLetter.Load()
+	if dlq == nil {
+		return
+	}
+
+	if err := dlq.write(p, message, priority, tag, fields, cause); err != nil {
+		logWarning("Writing to dead letter pipe %s failed: %s", dlq.path, err.Error())
+		return
+	}
+
+	statsFor(p.source()).deadLettered.Add(1)
+}
+
+// deadLetterDrop returns the dropFunc of an output of p queueing messages,
+// writing the messages it drops to the dead letter pipe.
+func deadLetterDrop(p pipe, priority syslog.Priority) dropFunc {
+	return func(message string, fields map[string]string, cause error) {
+		writeDeadLetter(p, message, priority, p.Tag, fields, cause)
+	}
+}
+
+// write writes a message that couldn't be delivered by p. If the pipe is
+// full, the message is lost.
+func (q *deadLetterQueue) write(p pipe, message string, priority syslog.Priority, tag string, fields map[string]string, cause error) error {
+	line, err := json.Marshal(deadLetterRecord{
+		jsonRecord: jsonRecord{
+			Time:     time.Now().UTC().Format(time.RFC3339Nano),
+			Facility: facilityName(priority),
+			Severity: severityName(priority),
+			Tag:      tag,
+			Message:  strings.TrimSuffix(message, "\n"),
+			Pipe:     p.source(),
+			Fields:   fields,
+		},
+		Destination: p.destination(),
+		Error:       cause.Error(),
+	})
+	if err != nil {
+		return err
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := q.fd.SetWriteDeadline(time.Now().Add(deadLetterTimeout)); err != nil {
+		return err
+	}
+
+	_, err = q.fd.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+	"time"
+)
+
+const defaultDedupeCacheSize = 1000
+
+// dedupeEntry is a message seen within the dedupe window.
+type dedupeEntry struct {
+	message  string
+	first    time.Time
+	repeated int
+	priority syslog.Priority
+	tag      string
+	fields   map[string]string
+}
+
+// dedupeSummary is a message to send when repeats were suppressed.
+type dedupeSummary struct {
+	message  string
+	priority syslog.Priority
+	tag      string
+	fields   map[string]string
+}
+
+// dedupe suppresses identical messages within a window. When the window
+// expires, a summary with the number of repeats is sent.
+type dedupe struct {
+	window  time.Duration
+	size    int
+	seen    map[dedupeKey]*dedupeEntry
+	pending []dedupeSummary
+}
+
+// dedupeKey identifies identical messages. Messages with different tags
+// are not identical.
+type dedupeKey struct {
+	message string
+	tag     string
+}
+
+// newDedupe returns the dedupe of a pipe, or nil if dedup_window is not set.
+func newDedupe(p pipe) *dedupe {
+	if p.DedupeWindow <= 0 {
+		return nil
+	}
+
+	size := p.DedupeCacheSize
+	if size <= 0 {
+		size = defaultDedupeCacheSize
+	}
+
+	return &dedupe{
+		window: p.DedupeWindow,
+		size:   size,
+		seen:   make(map[dedupeKey]*dedupeEntry),
+	}
+}
+
+// check returns true if the message should be forwarded, and false if it's
+// a repeat within the window.
+func (d *dedupe) check(message string, priority syslog.Priority, tag string, fields map[string]string, now time.Time) bool {
+	if d == nil {
+		return true
+	}
+
+	key := dedupeKey{message: strings.TrimSuffix(message, "\n"), tag: tag}
+	entry, found := d.seen[key]
+	if found && now.Sub(entry.first) < d.window {
+		entry.repeated++
+		return false
+	}
+
+	if found {
+		d.summarize(entry)
+	} else if len(d.seen) >= d.size {
+		d.evict()
+	}
+
+	d.seen[key] = &dedupeEntry{message: key.message, first: now, priority: priority, tag: tag, fields: fields}
+
+	return true
+}
+
+// evict removes the oldest message from the cache.
+func (d *dedupe) evict() {
+	var oldest dedupeKey
+	var oldestEntry *dedupeEntry
+	for key, entry := range d.seen {
+		if oldestEntry == nil || entry.first.Before(oldestEntry.first) {
+			oldest, oldestEntry = key, entry
+		}
+	}
+
+	d.summarize(oldestEntry)
+	delete(d.seen, oldest)
+}
+
+func (d *dedupe) summarize(entry *dedupeEntry) {
+	if entry.repeated == 0 {
+		return
+	}
+
+	d.pending = append(d.pending, dedupeSummary{
+		message:  fmt.Sprintf("%s (repeated %d times)", entry.message, entry.repeated),
+		priority: entry.priority,
+		tag:      entry.tag,
+		fields:   entry.fields,
+	})
+}
+
+// expire removes messages older than the window, and returns the summaries
+// to send.
+func (d *dedupe) expire(now time.Time) []dedupeSummary {
+	for key, entry := range d.seen {
+		if now.Sub(entry.first) >= d.window {
+			d.summarize(entry)
+			delete(d.seen, key)
+		}
+	}
+
+	summaries := d.pending
+	d.pending = nil
+
+	return summaries
+}
+
+// flush returns the summaries of all messages in the cache.
+func (d *dedupe) flush() []dedupeSummary {
+	for key, entry := range d.seen {
+		d.summarize(entry)
+		delete(d.seen, key)
+	}
+
+	summaries := d.pending
+	d.pending = nil
+
+	return summaries
+}
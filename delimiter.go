@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Line delimiters. Custom delimiters are given as a hexadecimal byte like
+// "0x1e"
+const (
+	delimiterLF   = "lf"
+	delimiterCRLF = "crlf"
+	delimiterNUL  = "nul"
+	delimiterNone = "none"
+)
+
+// parseDelimiter returns the bytes ending a line for a line_delimiter
+// setting. It returns nil for "none", where each read is a line.
+func parseDelimiter(setting string) ([]byte, error) {
+	switch setting {
+	case "", delimiterLF:
+		return []byte{'\n'}, nil
+	case delimiterCRLF:
+		return []byte("\r\n"), nil
+	case delimiterNUL:
+		return []byte{0}, nil
+	case delimiterNone:
+		return nil, nil
+	}
+
+	if hex, ok := strings.CutPrefix(setting, "0x"); ok {
+		b, err := strconv.ParseUint(hex, 16, 8)
+		if err == nil {
+			return []byte{byte(b)}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown line_delimiter (%s)", setting)
+}
+
+// splitLines returns the split function for the lines of the pipe. Lines
+// longer than the buffer size are split instead of failing the scanner.
+func (p pipe) splitLines() bufio.SplitFunc {
+	delimiter, _ := parseDelimiter(p.LineDelimiter)
+
+	return limitSplit(splitDelimiter(delimiter), p.bufferSize())
+}
+
+// splitDelimiter returns a split function for lines ending with delimiter.
+// For the default "\n" it's bufio.ScanLines, which also drops a carriage
+// return before the newline. Without a delimiter, all data read is a line.
+func splitDelimiter(delimiter []byte) bufio.SplitFunc {
+	switch {
+	case delimiter == nil:
+		return func(data []byte, atEOF bool) (int, []byte, error) {
+			if len(data) == 0 {
+				return 0, nil, nil
+			}
+
+			return len(data), data, nil
+		}
+
+	case bytes.Equal(delimiter, []byte{'\n'}):
+		return bufio.ScanLines
+	}
+
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+
+		if i := bytes.Index(data, delimiter); i >= 0 {
+			return i + len(delimiter), data[:i], nil
+		}
+
+		// Like bufio.ScanLines, a last line without delimiter is passed
+		// as is
+		if atEOF {
+			return len(data), data, nil
+		}
+
+		return 0, nil, nil
+	}
+}
+
+// limitSplit wraps a split function so lines are at most maxSize long.
+func limitSplit(split bufio.SplitFunc, maxSize int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if len(token) > maxSize || (advance == 0 && token == nil && err == nil && len(data) >= maxSize) {
+			return maxSize, data[:maxSize], nil
+		}
+
+		return advance, token, err
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"os"
+)
+
+// describeInput describes the input of a pipe, and for named pipes whether
+// it exists already.
+func describeInput(p pipe) string {
+	switch {
+	case p.ListenTCP != "":
+		return "TCP on " + p.ListenTCP
+	case p.ListenUnix != "":
+		return "unix socket " + p.ListenUnix
+	case p.ListenUnixAbstract != "":
+		return "abstract unix socket @" + p.ListenUnixAbstract
+	case p.ListenUDP != "":
+		return "UDP on " + p.ListenUDP
+	case p.TailFile != "":
+		return "tailing " + p.TailFile
+	case p.Exec != "":
+		return "command " + p.Exec
+	case p.Path == stdinPath:
+		return "stdin"
+	}
+
+	fileInfo, err := os.Stat(p.Path)
+	switch {
+	case err != nil:
+		return "named pipe " + p.Path + ", will be created"
+	case fileInfo.Mode()&os.ModeNamedPipe == 0:
+		return "named pipe " + p.Path + ", exists but is not a named pipe"
+	}
+
+	return "named pipe " + p.Path + ", exists"
+}
+
+// tryOutput connects to the output of a pipe and closes the connection
+// again. Outputs that would create files or only connect when sending are
+// not tried.
+func tryOutput(p pipe, priority syslog.Priority) string {
+	switch {
+	case p.Output == outputJSON:
+		return "not tried"
+	case p.usesHTTP():
+		return "not tried"
+	}
+
+	dial, err := outputDialer(p, priority)
+	if err != nil {
+		return "failed: " + err.Error()
+	}
+
+	writer, err := dial()
+	if err != nil {
+		return "failed: " + err.Error()
+	}
+	writer.Close()
+
+	return "OK"
+}
+
+// dryRun validates the configuration file and prints what logpipe would do
+// for each pipe, without creating any pipes. It returns the exit code.
+func dryRun(path string) int {
+	if code := validateConfig(path); code != exitOK {
+		return code
+	}
+
+	config, _, err := decodeConfig(path)
+	if err != nil {
+		fmt.Printf("Configuration error: %s\n", err.Error())
+		return exitConfigError
+	}
+
+	code := exitOK
+	for _, p := range config.Pipe {
+		facility := facilities[p.Facility]
+		severity := severities[p.Severity]
+		priority := facility | severity
+
+		fmt.Printf("\n%s\n", p.source())
+		fmt.Printf("  input:     %s\n", describeInput(p))
+		fmt.Printf("  facility:  %s (%d)\n", p.Facility, facility>>3)
+		fmt.Printf("  severity:  %s (%d)\n", p.Severity, severity)
+		fmt.Printf("  priority:  %d\n", priority)
+
+		outputs := p.Outputs
+		if len(outputs) == 0 {
+			outputs = []pipe{p}
+		}
+
+		for _, output := range outputs {
+			result := tryOutput(output, priority)
+			if result != "OK" && result != "not tried" {
+				code = exitRuntimeError
+			}
+
+			fmt.Printf("  output:    %s, %s\n", output.destination(), result)
+		}
+	}
+
+	return code
+}
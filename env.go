@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// envExpander expands environment variables in configuration values, and
+// remembers the ones that were unset or empty.
+type envExpander struct {
+	missing map[string]bool
+}
+
+func (e *envExpander) expand(value string) string {
+	return os.Expand(value, func(name string) string {
+		v := os.Getenv(name)
+		if v == "" {
+			e.missing[name] = true
+		}
+
+		return v
+	})
+}
+
+func (e *envExpander) expandMap(values map[string]string) {
+	for key, value := range values {
+		values[key] = e.expand(value)
+	}
+}
+
+// expandPipe expands environment variables in the fields of a pipe that
+// commonly hold secrets or differ between hosts. Paths identifying the pipe
+// and regular expressions are not expanded.
+func (e *envExpander) expandPipe(p pipe) pipe {
+	for _, field := range []*string{
+		&p.Tag,
+		&p.Hostname,
+		&p.Address,
+		&p.OutputPath,
+		&p.TLSCert,
+		&p.TLSKey,
+		&p.TLSCA,
+		&p.SplunkToken,
+		&p.DatadogAPIKey,
+		&p.LokiUsername,
+		&p.LokiPassword,
+		&p.KafkaTopic,
+		&p.KafkaUsername,
+		&p.KafkaPassword,
+	} {
+		*field = e.expand(*field)
+	}
+
+	p.KafkaBrokers = slices.Clone(p.KafkaBrokers)
+	for i, broker := range p.KafkaBrokers {
+		p.KafkaBrokers[i] = e.expand(broker)
+	}
+
+	e.expandMap(p.StructuredData)
+	e.expandMap(p.LokiLabels)
+	e.expandMap(p.Labels)
+
+	for i, output := range p.Outputs {
+		p.Outputs[i] = e.expandPipe(output)
+	}
+
+	return p
+}
+
+// expandEnv expands environment variables in the pipes of the
+// configuration. With require_env, referencing an unset or empty variable
+// is an error.
+func expandEnv(config *config) error {
+	e := &envExpander{missing: make(map[string]bool)}
+
+	for i, p := range config.Pipe {
+		config.Pipe[i] = e.expandPipe(p)
+	}
+
+	if !config.RequireEnv || len(e.missing) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(e.missing))
+	for name := range e.missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Errorf("environment variables are unset or empty: %s", strings.Join(names, ", "))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("LOGPIPE_TEST_TOKEN", "s3cret")
+	t.Setenv("LOGPIPE_TEST_HOST", "loghost")
+	t.Setenv("LOGPIPE_TEST_EMPTY", "")
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain", "plain"},
+		{"$LOGPIPE_TEST_TOKEN", "s3cret"},
+		{"${LOGPIPE_TEST_HOST}:514", "loghost:514"},
+		{"Bearer ${LOGPIPE_TEST_TOKEN}", "Bearer s3cret"},
+		{"$LOGPIPE_TEST_UNSET", ""},
+		{"${LOGPIPE_TEST_EMPTY}x", "x"},
+	}
+
+	for _, test := range tests {
+		config := config{Pipe: []pipe{{
+			Path:        "/tmp/$LOGPIPE_TEST_HOST",
+			Tag:         test.value,
+			SplunkToken: test.value,
+			Outputs:     []pipe{{Address: test.value}},
+		}}}
+
+		if err := expandEnv(&config); err != nil {
+			t.Fatal(err)
+		}
+
+		p := config.Pipe[0]
+		if p.Tag != test.want || p.SplunkToken != test.want || p.Outputs[0].Address != test.want {
+			t.Errorf("%q expanded to %q, %q and %q, want %q", test.value, p.Tag, p.SplunkToken, p.Outputs[0].Address, test.want)
+		}
+
+		// The path identifies the pipe and is kept as is
+		if p.Path != "/tmp/$LOGPIPE_TEST_HOST" {
+			t.Errorf("path expanded to %q", p.Path)
+		}
+	}
+}
+
+func TestExpandEnvMapsAndLists(t *testing.T) {
+	t.Setenv("LOGPIPE_TEST_HOST", "loghost")
+
+	brokers := []string{"${LOGPIPE_TEST_HOST}:9092"}
+	config := config{Pipe: []pipe{{
+		KafkaBrokers: brokers,
+		Labels:       map[string]string{"host": "$LOGPIPE_TEST_HOST"},
+	}}}
+
+	if err := expandEnv(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	p := config.Pipe[0]
+	if !slices.Equal(p.KafkaBrokers, []string{"loghost:9092"}) || p.Labels["host"] != "loghost" {
+		t.Errorf("expanded to %q and %v", p.KafkaBrokers, p.Labels)
+	}
+	if brokers[0] != "${LOGPIPE_TEST_HOST}:9092" {
+		t.Error("expanding modified the decoded list of brokers")
+	}
+}
+
+func TestExpandEnvRequire(t *testing.T) {
+	t.Setenv("LOGPIPE_TEST_TOKEN", "s3cret")
+	t.Setenv("LOGPIPE_TEST_EMPTY", "")
+
+	config := config{
+		RequireEnv: true,
+		Pipe: []pipe{{
+			Tag:           "$LOGPIPE_TEST_TOKEN",
+			SplunkToken:   "$LOGPIPE_TEST_UNSET",
+			KafkaPassword: "${LOGPIPE_TEST_EMPTY}",
+		}},
+	}
+
+	err := expandEnv(&config)
+	if err == nil || err.Error() != "environment variables are unset or empty: LOGPIPE_TEST_EMPTY, LOGPIPE_TEST_UNSET" {
+		t.Errorf("expandEnv returned %v", err)
+	}
+
+	config.Pipe = []pipe{{Tag: "$LOGPIPE_TEST_TOKEN"}}
+	if err := expandEnv(&config); err != nil {
+		t.Errorf("expandEnv returned %v with all variables set", err)
+	}
+}
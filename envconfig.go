@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// envConfigVar enables reading pipes from environment variables
+	envConfigVar = "LOGPIPE_CONFIG_ENV"
+
+	// maxEnvPipes is the number of pipes that can be configured with
+	// environment variables
+	maxEnvPipes = 100
+)
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// envConfigEnabled returns true if pipes should be read from environment
+// variables.
+func envConfigEnabled() bool {
+	return os.Getenv(envConfigVar) == "1"
+}
+
+// setFromEnv sets a field of a pipe from the value of an environment
+// variable. It returns false for fields that can't be set this way.
+func setFromEnv(field reflect.Value, value string) (bool, error) {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return true, err
+		}
+		field.SetInt(int64(d))
+
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return true, err
+		}
+		field.SetBool(b)
+
+	case field.Kind() == reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return true, err
+		}
+		field.SetInt(int64(i))
+
+	case field.Kind() == reflect.Pointer && field.Type().Elem().Kind() == reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return true, err
+		}
+		field.Set(reflect.ValueOf(&i))
+
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		field.Set(reflect.ValueOf(strings.Split(value, ",")))
+
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// applyEnv sets the fields of p from the LOGPIPE_PIPE_<n>_<KEY> environment
+// variables, where KEY is the upper-case TOML key. Lists are separated by
+// commas, and tables like structured_data can't be set. It returns false if
+// no variable was set for the pipe.
+func applyEnv(p *pipe, n int) (bool, error) {
+	found := false
+	prefix := fmt.Sprintf("LOGPIPE_PIPE_%d_", n)
+
+	v := reflect.ValueOf(p).Elem()
+	for i := range v.NumField() {
+		key := v.Type().Field(i).Tag.Get("toml")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		value, set := os.LookupEnv(prefix + strings.ToUpper(key))
+		if !set {
+			continue
+		}
+		found = true
+
+		// The output can also be a list of tables in the file, but only
+		// a single output type in the environment
+		if key == "output" {
+			p.Output = value
+			p.Outputs = nil
+			continue
+		}
+
+		ok, err := setFromEnv(v.Field(i), value)
+		if err != nil {
+			return true, fmt.Errorf("%s%s has invalid value (%s): %w", prefix, strings.ToUpper(key), value, err)
+		}
+		if !ok {
+			return true, fmt.Errorf("%s can't be set with environment variables (%s%s)", key, prefix, strings.ToUpper(key))
+		}
+	}
+
+	return found, nil
+}
+
+// configFromEnv returns base with pipes configured by environment
+// variables. Variables for the pipes of base override their settings,
+// variables for later pipe numbers add pipes.
+func configFromEnv(base config) (config, error) {
+	for n := range maxEnvPipes {
+		if n < len(base.Pipe) {
+			if _, err := applyEnv(&base.Pipe[n], n); err != nil {
+				return base, err
+			}
+			continue
+		}
+
+		var p pipe
+		found, err := applyEnv(&p, n)
+		if err != nil {
+			return base, err
+		}
+		if found {
+			base.Pipe = append(base.Pipe, p)
+		}
+	}
+
+	return base, nil
+}
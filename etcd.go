@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultEtcdEndpoint = "127.0.0.1:2379"
+	defaultEtcdKey      = "/logpipe/config"
+
+	// etcdTimeout limits connecting and reading the key
+	etcdTimeout = 10 * time.Second
+
+	// etcdRetry is how long to wait before watching again after the watch
+	// failed
+	etcdRetry = 5 * time.Second
+)
+
+// errEtcdCompacted ends a watch resuming at a revision no longer kept.
+var errEtcdCompacted = errors.New("watched revision was compacted")
+
+// etcdClient reads the configuration from a key in etcd with the v3
+// client, which fails over between the endpoints.
+type etcdClient struct {
+	client *clientv3.Client
+	key    string
+}
+
+func newEtcdClient(c config) (*etcdClient, error) {
+	endpoints := c.EtcdEndpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{defaultEtcdEndpoint}
+	}
+
+	key := c.EtcdKey
+	if key == "" {
+		key = defaultEtcdKey
+	}
+
+	// The TLS settings are those of a pipe, with etcd_ prefixes. Without
+	// them, the endpoints are connected to without TLS
+	settings := clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: etcdTimeout,
+
+		// Failures are logged by us
+		Logger: zap.NewNop(),
+	}
+
+	tlsSettings := pipe{TLSCert: c.EtcdTLSCert, TLSKey: c.EtcdTLSKey, TLSCA: c.EtcdTLSCA}
+	if tlsSettings.usesTLS() {
+		config, err := tlsConfig(tlsSettings)
+		if err != nil {
+			return nil, err
+		}
+
+		settings.TLS = config
+	}
+
+	client, err := clientv3.New(settings)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to etcd failed: %w", err)
+	}
+
+	return &etcdClient{client: client, key: key}, nil
+}
+
+// read returns the configuration stored in etcd.
+func (c *etcdClient) read() ([]byte, error) {
+	value, _, err := c.get()
+	return value, err
+}
+
+// get returns the value of the key and the revision of the store.
+func (c *etcdClient) get() ([]byte, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	response, err := c.client.Get(ctx, c.key)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading %s from etcd failed: %w", c.key, err)
+	}
+
+	if len(response.Kvs) == 0 {
+		return nil, 0, fmt.Errorf("%s not found in etcd", c.key)
+	}
+
+	return response.Kvs[0].Value, response.Header.Revision, nil
+}
+
+// watch calls changed for each change of the key. When the watch breaks,
+// it's resumed after the last revision seen, so no change is missed. If
+// that revision was compacted away, changes may have been missed, and
+// changed is called to be sure.
+func (c *etcdClient) watch(changed func()) {
+	_, revision, err := c.get()
+	if err != nil {
+		logWarning("Watching configuration in etcd failed: %s", err.Error())
+	}
+
+	for {
+		revision, err = c.watchFrom(revision, changed)
+		if errors.Is(err, errEtcdCompacted) {
+			changed()
+			continue
+		}
+
+		logWarning("Watching configuration in etcd failed: %s", err.Error())
+		time.Sleep(etcdRetry)
+	}
+}
+
+// watchFrom watches the key starting after revision. It returns the last
+// revision seen when the watch breaks.
+func (c *etcdClient) watchFrom(revision int64, changed func()) (int64, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var options []clientv3.OpOption
+	if revision > 0 {
+		options = append(options, clientv3.WithRev(revision+1))
+	}
+
+	// Requiring a leader ends the watch on a member cut off from the
+	// cluster, so the client moves on to another
+	for response := range c.client.Watch(clientv3.WithRequireLeader(ctx), c.key, options...) {
+		if response.CompactRevision > 0 {
+			return response.CompactRevision, errEtcdCompacted
+		}
+
+		if err := response.Err(); err != nil {
+			return revision, err
+		}
+
+		if response.Header.Revision > revision {
+			revision = response.Header.Revision
+		}
+
+		if len(response.Events) > 0 {
+			changed()
+		}
+	}
+
+	return revision, errors.New("watch was closed")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"maps"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Event formats wrapping messages for SIEMs
+const (
+	eventFormatCEF  = "cef"
+	eventFormatLEEF = "leef"
+)
+
+// defaultCEFSeverities maps syslog severities to CEF severities, where 10
+// is the most severe.
+var defaultCEFSeverities = map[syslog.Priority]int{
+	syslog.LOG_EMERG:   10,
+	syslog.LOG_ALERT:   9,
+	syslog.LOG_CRIT:    8,
+	syslog.LOG_ERR:     7,
+	syslog.LOG_WARNING: 6,
+	syslog.LOG_NOTICE:  4,
+	syslog.LOG_INFO:    3,
+	syslog.LOG_DEBUG:   1,
+}
+
+// cefSeverities is the mapping used by CEF and LEEF events, the defaults
+// with the [cef_severity_map] section applied.
+var cefSeverities atomic.Pointer[map[syslog.Priority]int]
+
+// checkCEFSeverityMap validates the [cef_severity_map] section.
+func checkCEFSeverityMap(m map[string]int) []error {
+	var errs []error
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, found := severities[name]; !found {
+			errs = append(errs, fmt.Errorf("cef_severity_map has unknown severity (%s)", name))
+		} else if m[name] < 0 || m[name] > 10 {
+			errs = append(errs, fmt.Errorf("cef_severity_map has %s outside 0 to 10 (%d)", name, m[name]))
+		}
+	}
+
+	return errs
+}
+
+// setCEFSeverityMap applies the [cef_severity_map] section. Severities not
+// in the section keep their default.
+func setCEFSeverityMap(m map[string]int) {
+	mapping := maps.Clone(defaultCEFSeverities)
+	for name, value := range m {
+		mapping[severities[name]] = value
+	}
+
+	cefSeverities.Store(&mapping)
+}
+
+// cefSeverity returns the CEF severity of a priority.
+func cefSeverity(priority syslog.Priority) int {
+	mapping := cefSeverities.Load()
+	if mapping == nil {
+		return defaultCEFSeverities[priority&0x07]
+	}
+
+	return (*mapping)[priority&0x07]
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// formatEvent wraps a message in the output_format of the pipe.
+func (p pipe) formatEvent(message string, priority syslog.Priority, tag string) string {
+	switch p.OutputFormat {
+	case eventFormatCEF:
+		return formatCEF(message, priority, tag)
+	case eventFormatLEEF:
+		return formatLEEF(p.LEEFVersion, message, priority, tag, time.Now())
+	}
+
+	return message
+}
+
+// formatCEF formats a message as a CEF event. The tag is the signature id,
+// and the message both the name and the msg extension.
+func formatCEF(message string, priority syslog.Priority, tag string) string {
+	return fmt.Sprintf("CEF:0|Logpipe|logpipe|%s|%s|%s|%d|msg=%s",
+		cefHeaderEscaper.Replace(Version),
+		cefHeaderEscaper.Replace(tag),
+		cefHeaderEscaper.Replace(message),
+		cefSeverity(priority),
+		cefExtensionEscaper.Replace(message))
+}
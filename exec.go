@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// execStopTimeout is how long a command is given to exit after SIGTERM
+// before it's killed.
+const execStopTimeout = 5 * time.Second
+
+// startCommand starts a command and returns it with the read end of its
+// stdout. Lines written to stderr are logged as errors. The command is
+// split on whitespace, it's not run by a shell.
+func startCommand(ctx context.Context, command string) (*exec.Cmd, *os.File, error) {
+	args := strings.Fields(command)
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = execStopTimeout
+
+	// We read stdout from our own pipe, as readPipe needs read deadlines
+	stdout, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd.Stdout = w
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		stdout.Close()
+		w.Close()
+		return nil, nil, err
+	}
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		stdout.Close()
+		return nil, nil, err
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logError("%s: %s", args[0], scanner.Text())
+		}
+	}()
+
+	return cmd, stdout, nil
+}
+
+// readCommand passes each line a command writes to stdout to handle until
+// ctx is cancelled. The command is restarted with exponential backoff when
+// it exits. It only returns an error if the command can't be started the
+// first time.
+func readCommand(ctx context.Context, p pipe, handle func(string), flush func()) error {
+	stats := statsFor(p.source())
+
+	backoff := minBackoff
+	for first := true; ; first = false {
+		cmd, stdout, err := startCommand(ctx, p.Exec)
+		if err != nil {
+			if first {
+				return err
+			}
+
+			stats.errors.Add(1)
+			logError("Restarting %s failed: %s", p.Exec, err.Error())
+		} else {
+			started := time.Now()
+
+			err = readPipe(ctx, stdout, p.bufferSize(), p.splitLines(), handle)
+			stdout.Close()
+			flush()
+
+			if err != nil {
+				stats.errors.Add(1)
+				logError("Reading from %s failed: %s", p.Exec, err.Error())
+			}
+
+			// After reading fails, the command may still be running
+			if err != nil && ctx.Err() == nil {
+				cmd.Process.Kill()
+			}
+
+			err = cmd.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			var exitErr *exec.ExitError
+			switch {
+			case errors.As(err, &exitErr):
+				stats.errors.Add(1)
+				logWarning("%s exited: %s", p.Exec, err.Error())
+			case err != nil:
+				stats.errors.Add(1)
+				logWarning("Waiting for %s failed: %s", p.Exec, err.Error())
+			default:
+				logWarning("%s exited", p.Exec)
+			}
+
+			// A command that ran for a while is restarted quickly
+			if time.Since(started) > maxBackoff {
+				backoff = minBackoff
+			}
+		}
+
+		backoff = sleepBackoff(ctx, backoff)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		stats.restarts.Add(1)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"maps"
+
+	"github.com/BurntSushi/toml"
+)
+
+// outputKeys are the settings allowed in a [[pipe.output]] table.
+var outputKeys = map[string]bool{
+	"type":                 true,
+	"tag":                  true,
+	"network":              true,
+	"address":              true,
+	"output_path":          true,
+	"reconnect_buffer":     true,
+	"keepalive_interval":   true,
+	"failure_threshold":    true,
+	"open_duration":        true,
+	"tls_cert":             true,
+	"tls_key":              true,
+	"tls_ca":               true,
+	"insecure_skip_verify": true,
+	"format":               true,
+	"sd_id":                true,
+	"structured_data":      true,
+	"tcp_framing":          true,
+	"syslog_sockets":       true,
+	"batch_size":           true,
+	"batch_timeout":        true,
+	"splunk_token":         true,
+	"datadog_api_key":      true,
+	"datadog_site":         true,
+	"loki_labels":          true,
+	"loki_username":        true,
+	"loki_password":        true,
+	"kafka_brokers":        true,
+	"kafka_topic":          true,
+	"kafka_partitioner":    true,
+	"kafka_sasl_mechanism": true,
+	"kafka_username":       true,
+	"kafka_password":       true,
+}
+
+// outputTable is a [[pipe.output]] table. The output settings are decoded
+// on top of a copy of the pipe, so anything not set is inherited.
+type outputTable struct {
+	Type string `toml:"type"`
+	pipe
+}
+
+// decodeOutputs decodes the output setting of a pipe. It's either the name
+// of a single output, or a list of [[pipe.output]] tables.
+func decodeOutputs(meta toml.MetaData, p *pipe) error {
+	if err := meta.PrimitiveDecode(p.RawOutput, &p.Output); err == nil {
+		return nil
+	}
+
+	var tables []map[string]any
+	if err := meta.PrimitiveDecode(p.RawOutput, &tables); err != nil {
+		return fmt.Errorf("%s: output must be a string or a list of [[pipe.output]] tables", p.source())
+	}
+
+	outputs := make([]outputTable, len(tables))
+	for i, table := range tables {
+		for key := range table {
+			if !outputKeys[key] {
+				return fmt.Errorf("%s: %s can't be set in [[pipe.output]]", p.source(), key)
+			}
+		}
+
+		outputs[i].pipe = *p
+		outputs[i].pipe.RawOutput = toml.Primitive{}
+
+		// Maps are merged when decoding, don't modify the ones of the pipe
+		outputs[i].pipe.StructuredData = maps.Clone(p.StructuredData)
+		outputs[i].pipe.LokiLabels = maps.Clone(p.LokiLabels)
+	}
+
+	if err := meta.PrimitiveDecode(p.RawOutput, &outputs); err != nil {
+		return fmt.Errorf("%s: %s", p.source(), err.Error())
+	}
+
+	for _, output := range outputs {
+		output.pipe.Output = output.Type
+		p.Outputs = append(p.Outputs, output.pipe)
+	}
+
+	return nil
+}
+
+// fanoutWriter writes each message to all outputs of a pipe.
+type fanoutWriter []io.WriteCloser
+
+// openFanout connects to a list of outputs. Each output is re-dialed in the
+// background if it fails, so a failing output doesn't hold back the others.
+func openFanout(outputs []pipe, priority syslog.Priority) (fanoutWriter, error) {
+	var w fanoutWriter
+
+	for _, output := range outputs {
+		dial, err := outputDialer(output, priority)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+
+		w = append(w, newReconnectWriter(output, nil, dial, deadLetterDrop(output, priority)))
+	}
+
+	return w, nil
+}
+
+func (w fanoutWriter) Write(b []byte) (int, error) {
+	return w.WriteFields(b, nil)
+}
+
+func (w fanoutWriter) WriteFields(b []byte, fields map[string]string) (int, error) {
+	var errs []error
+
+	for _, writer := range w {
+		_, err := writeFields(writer, b, fields)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return len(b), errors.Join(errs...)
+}
+
+func (w fanoutWriter) Close() error {
+	var errs []error
+
+	for _, writer := range w {
+		errs = append(errs, writer.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// drainTimeout is how long we keep reading from a pipe after being asked to
+// stop.
+const drainTimeout = 100 * time.Millisecond
+
+// fifoCheckInterval is how often we check that a named pipe waiting for a
+// writer wasn't removed.
+const fifoCheckInterval = time.Second
+
+// errFifoRemoved is returned by waitWriter when the named pipe was removed
+// or replaced while waiting.
+var errFifoRemoved = errors.New("named pipe was removed")
+
+const (
+	// initialBufferSize is the size of the read buffer of pipes. The
+	// buffer grows up to the maximum line length as needed
+	initialBufferSize = 4096
+
+	// defaultBufferSize is the maximum line length of pipes
+	defaultBufferSize = bufio.MaxScanTokenSize
+)
+
+// bufferSize returns the maximum line length of the pipe.
+func (p pipe) bufferSize() int {
+	if p.BufferSize > 0 {
+		return p.BufferSize
+	}
+
+	return defaultBufferSize
+}
+
+// tunePipe applies the kernel buffer size of the pipe to fd. Failing to
+// set it is only reported.
+func (p pipe) tunePipe(fd *os.File) {
+	if p.PipeKernelBuffer <= 0 {
+		return
+	}
+
+	if err := setPipeSize(fd, p.PipeKernelBuffer); err != nil {
+		logWarning("Setting pipe_kernel_buffer of %s failed: %s", p.Path, err.Error())
+	}
+}
+
+// createFifo creates a named pipe at path if it doesn't exist already. The
+// mode is applied regardless of umask. If uid or gid is not -1, the owner of
+// the pipe is changed.
+func createFifo(path string, mode os.FileMode, uid, gid int) error {
+	fileInfo, err := os.Stat(path)
+	if err == nil {
+		if (fileInfo.Mode() & os.ModeNamedPipe) == 0 {
+			return fmt.Errorf("%s exists, but it's not a named pipe (FIFO)", path)
+		}
+
+		return nil
+	}
+
+	if err := syscall.Mkfifo(path, uint32(mode)); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+
+	if uid != -1 || gid != -1 {
+		return os.Lchown(path, uid, gid)
+	}
+
+	return nil
+}
+
+// preopened holds named pipes opened at startup, keyed by path. These are
+// passed by systemd socket activation or opened before chrooting, and kept
+// open for the lifetime of logpipe since they can't be reopened.
+var preopened = make(map[string]*os.File)
+
+// preopenedFifo returns the named pipe at path if it was opened at startup,
+// or nil.
+func preopenedFifo(path string) *os.File {
+	return preopened[filepath.Clean(path)]
+}
+
+// preopenFifos opens the named pipes of all pipes for both reading and
+// writing. This doesn't block waiting for a writer, and keeps the pipes
+// usable after chrooting.
+func preopenFifos(pipes []pipe) error {
+	for _, p := range pipes {
+		if !p.isFifo() || preopenedFifo(p.Path) != nil {
+			continue
+		}
+
+		fd, err := os.OpenFile(p.Path, os.O_RDWR|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			return err
+		}
+		p.tunePipe(fd)
+
+		preopened[filepath.Clean(p.Path)] = fd
+	}
+
+	return nil
+}
+
+// openFifo creates the named pipe at path if needed and opens it for
+// reading. It returns when a writer has written to the pipe, or ctx is
+// cancelled. If the pipe is removed meanwhile, it's created again.
+func openFifo(ctx context.Context, path string, mode os.FileMode, uid, gid int) (*os.File, error) {
+	for {
+		fd, err := tryOpenFifo(ctx, path, mode, uid, gid)
+		if errors.Is(err, errFifoRemoved) {
+			logWarning("%s was removed, creating it again", path)
+			continue
+		}
+
+		return fd, err
+	}
+}
+
+func tryOpenFifo(ctx context.Context, path string, mode os.FileMode, uid, gid int) (*os.File, error) {
+	if err := createFifo(path, mode, uid, gid); err != nil {
+		return nil, err
+	}
+
+	// Opening a FIFO for reading blocks in the kernel until a writer opens
+	// it, which can't be interrupted. With O_NONBLOCK the open returns
+	// right away instead, but reading returns EOF as long as there's no
+	// writer. The Go runtime reads FIFOs in non-blocking mode anyway, so
+	// we keep the flag and wait for the pipe to become readable
+	fd, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := waitWriter(ctx, fd, path); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return fd, nil
+}
+
+// waitWriter waits until a writer has written to the named pipe fd, or has
+// opened and closed it again. Linux and the BSDs don't report a hangup for
+// a pipe that has never had a writer, so poll blocks until then. If ctx is
+// cancelled, we return early and reading fd drains whatever is there. No
+// writer can open fd anymore once path was removed, so then errFifoRemoved
+// is returned.
+func waitWriter(ctx context.Context, fd *os.File, path string) error {
+	conn, err := fd.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		fd.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	for {
+		// Once cancelled, the deadline must stay where the AfterFunc put it
+		fd.SetReadDeadline(time.Now().Add(fifoCheckInterval))
+		if ctx.Err() != nil {
+			fd.SetReadDeadline(time.Time{})
+			return nil
+		}
+
+		err = conn.Read(func(fd uintptr) bool {
+			fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+			n, err := unix.Poll(fds, 0)
+
+			// Returning false waits for the runtime poller to report the
+			// pipe readable, and tries again
+			return err != nil || (n > 0 && fds[0].Revents != 0)
+		})
+		if ctx.Err() != nil {
+			fd.SetReadDeadline(time.Time{})
+			return nil
+		}
+
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if !sameFile(path, fd) {
+				return errFifoRemoved
+			}
+			continue
+		}
+
+		fd.SetReadDeadline(time.Time{})
+		return err
+	}
+}
+
+// sameFile returns true if path still leads to the open file fd.
+func sameFile(path string, fd *os.File) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	opened, err := fd.Stat()
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(info, opened)
+}
+
+// readPipe passes each line read from fd to handle until the writer closes
+// the pipe or ctx is cancelled. Lines are split by split, which must not
+// return lines longer than bufferSize bytes, see splitLines.
+func readPipe(ctx context.Context, fd *os.File, bufferSize int, split bufio.SplitFunc, handle func(string)) error {
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(make([]byte, min(initialBufferSize, bufferSize)), bufferSize)
+	scanner.Split(split)
+
+	// When we're asked to stop, we keep reading for a short while to drain
+	// lines already written to the pipe
+	stop := context.AfterFunc(ctx, func() {
+		if err := fd.SetReadDeadline(time.Now().Add(drainTimeout)); err != nil {
+			fd.Close()
+		}
+	})
+	defer stop()
+
+	for scanner.Scan() {
+		handle(scanner.Text())
+	}
+
+	// The read deadline ends the loop when cancelled
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return scanner.Err()
+}
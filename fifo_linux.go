@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// setPipeSize sets the kernel buffer size of a named pipe. Sizes above
+// /proc/sys/fs/pipe-max-size need CAP_SYS_RESOURCE, without it the default
+// size is kept.
+func setPipeSize(fd *os.File, size int) error {
+	conn, err := fd.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_SETPIPE_SZ, uintptr(size))
+	})
+	if err != nil {
+		return err
+	}
+
+	if errno != 0 && !errors.Is(errno, syscall.EPERM) {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// setPipeSize does nothing, the pipe size can only be set on Linux.
+func setPipeSize(fd *os.File, size int) error {
+	return nil
+}
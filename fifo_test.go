@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A named pipe removed while waiting for a writer is created again, and
+// writes to the new one are read.
+func TestOpenFifoRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app_log")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opened := make(chan *os.File, 1)
+	go func() {
+		fd, err := openFifo(ctx, path, 0600, -1, -1)
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- fd
+	}()
+
+	// Wait for the reader to create the named pipe, and remove it
+	var created os.FileInfo
+	for deadline := time.Now().Add(5 * time.Second); created == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("named pipe not created")
+		}
+		created, _ = os.Stat(path)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	var recreated os.FileInfo
+	for deadline := time.Now().Add(5 * time.Second); recreated == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("named pipe not created again")
+		}
+		recreated, _ = os.Stat(path)
+	}
+	if recreated.Mode()&os.ModeNamedPipe == 0 {
+		t.Fatalf("%s is not a named pipe: %s", path, recreated.Mode())
+	}
+
+	writeFifo(t, path, "after removing")
+
+	fd := <-opened
+	if fd == nil {
+		t.FailNow()
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	if !scanner.Scan() || scanner.Text() != "after removing" {
+		t.Errorf("read %q, %v", scanner.Text(), scanner.Err())
+	}
+}
+
+// Cancelling returns right away, also between the checks for removal.
+func TestOpenFifoCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app_log")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	opened := make(chan error, 1)
+	go func() {
+		fd, err := openFifo(ctx, path, 0600, -1, -1)
+		if fd != nil {
+			fd.Close()
+		}
+		opened <- err
+	}()
+
+	time.Sleep(fifoCheckInterval + 100*time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-opened:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(fifoCheckInterval / 2):
+		t.Error("openFifo did not return after cancelling")
+	}
+}
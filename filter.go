@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// lineFilter decides which lines are forwarded. Normally only lines matching
+// the regular expression are forwarded, when inverted only lines not
+// matching are forwarded.
+type lineFilter struct {
+	regex  *regexp.Regexp
+	invert bool
+}
+
+// newLineFilter returns a filter for the pipe, or nil if the pipe has no
+// filter configured.
+func newLineFilter(p pipe) (*lineFilter, error) {
+	if p.FilterRegex == "" {
+		return nil, nil
+	}
+
+	regex, err := regexp.Compile(p.FilterRegex)
+	if err != nil {
+		return nil, fmt.Errorf("%s has invalid filter_regex: %s", p.source(), err.Error())
+	}
+
+	return &lineFilter{
+		regex:  regex,
+		invert: p.FilterInvert,
+	}, nil
+}
+
+// pass returns true if the line should be forwarded.
+func (f *lineFilter) pass(line string) bool {
+	if f == nil {
+		return true
+	}
+
+	return f.regex.MatchString(strings.TrimSuffix(line, "\n")) != f.invert
+}
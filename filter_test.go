@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLineFilter(t *testing.T) {
+	tests := []struct {
+		regex  string
+		invert bool
+		line   string
+		pass   bool
+	}{
+		{"", false, "anything\n", true},
+		{"ERROR", false, "ERROR disk full\n", true},
+		{"ERROR", false, "INFO started\n", false},
+		{"ERROR", true, "ERROR disk full\n", false},
+		{"ERROR", true, "INFO started\n", true},
+
+		// The newline isn't part of the line matched
+		{"full$", false, "ERROR disk full\n", true},
+		{`^GET /healthz `, true, "GET /healthz HTTP/1.1\n", false},
+	}
+
+	for _, test := range tests {
+		f, err := newLineFilter(pipe{FilterRegex: test.regex, FilterInvert: test.invert})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pass := f.pass(test.line); pass != test.pass {
+			t.Errorf("filter %q (invert %v) passes %q: %v, want %v", test.regex, test.invert, test.line, pass, test.pass)
+		}
+	}
+}
+
+func TestLineFilterInvalid(t *testing.T) {
+	if _, err := newLineFilter(pipe{Path: "/tmp/app_log", FilterRegex: "("}); err == nil {
+		t.Error("invalid filter_regex accepted")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"log/syslog"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fluentdWriter sends messages to Fluentd with the Forward protocol, each
+// as a msgpack encoded [tag, time, record] message.
+type fluentdWriter struct {
+	conn         net.Conn
+	writeTimeout time.Duration
+
+	tag      string
+	host     string
+	facility string
+	severity string
+}
+
+func dialFluentd(p pipe, priority syslog.Priority) (*fluentdWriter, error) {
+	conn, err := p.dialer().Dial("tcp", p.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fluentdWriter{
+		conn:         conn,
+		writeTimeout: p.WriteTimeout,
+		tag:          p.Tag,
+		host:         p.hostname(),
+		facility:     facilityName(priority),
+		severity:     severityName(priority),
+	}, nil
+}
+
+func (w *fluentdWriter) Write(b []byte) (int, error) {
+	return w.WriteFields(b, nil)
+}
+
+func (w *fluentdWriter) WriteFields(b []byte, fields map[string]string) (int, error) {
+	record := make(map[string]string, len(fields)+4)
+	for k, v := range fields {
+		record[k] = v
+	}
+	record["message"] = strings.TrimSuffix(string(b), "\n")
+	record["facility"] = w.facility
+	record["severity"] = w.severity
+	record["host"] = w.host
+
+	if w.writeTimeout > 0 {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := w.conn.Write(fluentdMessage(w.tag, time.Now(), record)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (w *fluentdWriter) Close() error {
+	return w.conn.Close()
+}
+
+// fluentdMessage encodes a Forward protocol message. The time is sent as
+// EventTime, msgpack extension type 0 with seconds and nanoseconds.
+func fluentdMessage(tag string, t time.Time, record map[string]string) []byte {
+	b := []byte{0x93}
+	b = msgpackString(b, tag)
+
+	b = append(b, 0xd7, 0x00)
+	b = binary.BigEndian.AppendUint32(b, uint32(t.Unix()))
+	b = binary.BigEndian.AppendUint32(b, uint32(t.Nanosecond()))
+
+	keys := make([]string, 0, len(record))
+	for k := range record {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b = msgpackMapHeader(b, len(keys))
+	for _, k := range keys {
+		b = msgpackString(b, k)
+		b = msgpackString(b, record[k])
+	}
+
+	return b
+}
+
+// msgpackString appends s encoded as a msgpack str.
+func msgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0xdb)
+		b = binary.BigEndian.AppendUint32(b, uint32(n))
+	}
+
+	return append(b, s...)
+}
+
+// msgpackMapHeader appends the header of a msgpack map with n entries.
+func msgpackMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xde)
+		return binary.BigEndian.AppendUint16(b, uint16(n))
+	}
+
+	b = append(b, 0xdf)
+	return binary.BigEndian.AppendUint32(b, uint32(n))
+}
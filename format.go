@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// decodeFormat decodes a configuration file in the format given by its
+// extension: .yaml and .yml files are YAML, .json files are JSON, and all
+// others TOML. YAML and JSON use the same keys as TOML.
+func decodeFormat(path string, v any) (toml.MetaData, error) {
+	var unmarshal func([]byte, any) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		unmarshal = yaml.Unmarshal
+	case ".json":
+		unmarshal = unmarshalJSON
+	default:
+		return toml.DecodeFile(path, v)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return toml.MetaData{}, err
+	}
+
+	var doc map[string]any
+	if err := unmarshal(data, &doc); err != nil {
+		return toml.MetaData{}, err
+	}
+
+	// The document is decoded through TOML, so all formats share the
+	// struct tags, the output tables and the unknown key checks
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(normalize(doc)); err != nil {
+		return toml.MetaData{}, err
+	}
+
+	return toml.Decode(buf.String(), v)
+}
+
+// unmarshalJSON decodes JSON keeping integers as integers.
+func unmarshalJSON(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
+// normalize prepares a decoded YAML or JSON value for encoding as TOML.
+// Null values are dropped, as TOML has no null, and JSON numbers become
+// integers or floats.
+func normalize(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(value))
+		for k, v := range value {
+			if v != nil {
+				m[k] = normalize(v)
+			}
+		}
+		return m
+
+	case map[any]any:
+		m := make(map[string]any, len(value))
+		for k, v := range value {
+			if v != nil {
+				m[fmt.Sprint(k)] = normalize(v)
+			}
+		}
+		return m
+
+	case []any:
+		s := make([]any, 0, len(value))
+		for _, v := range value {
+			if v != nil {
+				s = append(s, normalize(v))
+			}
+		}
+		return s
+
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		if f, err := value.Float64(); err == nil {
+			return f
+		}
+		return value.String()
+
+	default:
+		return value
+	}
+}
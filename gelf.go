@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log/syslog"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// gelfChunkSize is the maximum size of a GELF datagram
+	gelfChunkSize = 8192
+
+	// gelfChunkHeaderSize is the size of the header of each chunk: two
+	// magic bytes, an 8 byte message id, the sequence number and the
+	// sequence count
+	gelfChunkHeaderSize = 12
+
+	// gelfMaxChunks is the maximum number of chunks allowed by the GELF
+	// specification
+	gelfMaxChunks = 128
+)
+
+// gelfFieldName matches the names GELF allows for additional fields.
+var gelfFieldName = regexp.MustCompile(`^[\w.\-]+$`)
+
+// gelfMessage is a GELF 1.1 message.
+type gelfMessage struct {
+	Version      string  `json:"version"`
+	Host         string  `json:"host"`
+	ShortMessage string  `json:"short_message"`
+	Timestamp    float64 `json:"timestamp"`
+	Level        int     `json:"level"`
+	Tag          string  `json:"_tag,omitempty"`
+}
+
+// gelfWriter sends messages to Graylog as GELF UDP datagrams.
+type gelfWriter struct {
+	conn  net.Conn
+	host  string
+	tag   string
+	level int
+}
+
+func dialGELF(p pipe, priority syslog.Priority) (*gelfWriter, error) {
+	conn, err := p.dialer().Dial("udp", p.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gelfWriter{
+		conn:  conn,
+		host:  p.hostname(),
+		tag:   p.Tag,
+		level: int(priority & 0x07),
+	}, nil
+}
+
+func (w *gelfWriter) Write(b []byte) (int, error) {
+	return w.WriteFields(b, nil)
+}
+
+// WriteFields sends a message with fields as GELF additional fields.
+func (w *gelfWriter) WriteFields(b []byte, fields map[string]string) (int, error) {
+	payload, err := w.encode(b, fields)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(payload) <= gelfChunkSize {
+		_, err = w.conn.Write(payload)
+	} else {
+		err = w.writeChunked(payload)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// encode returns the GELF message for b. Fields are added with a leading
+// underscore, leaving out names GELF doesn't allow and the tag.
+func (w *gelfWriter) encode(b []byte, fields map[string]string) ([]byte, error) {
+	payload, err := json.Marshal(gelfMessage{
+		Version:      "1.1",
+		Host:         w.host,
+		ShortMessage: strings.TrimSuffix(string(b), "\n"),
+		Timestamp:    float64(time.Now().UnixNano()) / float64(time.Second),
+		Level:        w.level,
+		Tag:          w.tag,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	additional := make(map[string]string, len(fields))
+	for name, value := range fields {
+		if gelfFieldName.MatchString(name) && name != "id" && name != "tag" {
+			additional["_"+name] = value
+		}
+	}
+	if len(additional) == 0 {
+		return payload, nil
+	}
+
+	// The additional fields go into the same JSON object
+	extra, err := json.Marshal(additional)
+	if err != nil {
+		return nil, err
+	}
+	payload = append(payload[:len(payload)-1], ',')
+
+	return append(payload, extra[1:]...), nil
+}
+
+// writeChunked splits payload into chunks as described by the GELF
+// specification.
+func (w *gelfWriter) writeChunked(payload []byte) error {
+	dataSize := gelfChunkSize - gelfChunkHeaderSize
+
+	count := (len(payload) + dataSize - 1) / dataSize
+	if count > gelfMaxChunks {
+		return errors.New("message too large for GELF")
+	}
+
+	chunk := make([]byte, gelfChunkSize)
+	chunk[0] = 0x1e
+	chunk[1] = 0x0f
+	if _, err := rand.Read(chunk[2:10]); err != nil {
+		return err
+	}
+	chunk[11] = byte(count)
+
+	for i := 0; i < count; i++ {
+		chunk[10] = byte(i)
+
+		data := payload[i*dataSize:]
+		if len(data) > dataSize {
+			data = data[:dataSize]
+		}
+
+		n := copy(chunk[gelfChunkHeaderSize:], data)
+		if _, err := w.conn.Write(chunk[:gelfChunkHeaderSize+n]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *gelfWriter) Close() error {
+	return w.conn.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultAlertTag      = "logpipe"
+	defaultAlertInterval = 30 * time.Second
+)
+
+type globalRateLimitConfig struct {
+	MessagesPerSecond int           `toml:"messages_per_second"`
+	AlertTag          string        `toml:"alert_tag"`
+	AlertInterval     time.Duration `toml:"alert_interval"`
+}
+
+// globalLimit limits the messages written by all pipes together. It's nil
+// without a [global_rate_limit] section.
+var globalLimit atomic.Pointer[globalRateLimiter]
+
+// globalRateLimiter blocks pipes while the total rate is exceeded. The
+// pipe first held back sends an alert, at most once per interval.
+type globalRateLimiter struct {
+	config  globalRateLimitConfig
+	limiter *rate.Limiter
+
+	lock      sync.Mutex
+	lastAlert time.Time
+}
+
+// checkGlobalRateLimit validates the [global_rate_limit] section.
+func checkGlobalRateLimit(c globalRateLimitConfig) []error {
+	var errs []error
+
+	if c.MessagesPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("messages_per_second can't be negative (%d)", c.MessagesPerSecond))
+	}
+	if c.AlertInterval < 0 {
+		errs = append(errs, errors.New("alert_interval can't be negative"))
+	}
+
+	return errs
+}
+
+// setGlobalRateLimit applies the [global_rate_limit] section. The limiter
+// is kept if the section didn't change.
+func setGlobalRateLimit(c globalRateLimitConfig) {
+	if c.MessagesPerSecond <= 0 {
+		globalLimit.Store(nil)
+		return
+	}
+
+	if c.AlertTag == "" {
+		c.AlertTag = defaultAlertTag
+	}
+	if c.AlertInterval <= 0 {
+		c.AlertInterval = defaultAlertInterval
+	}
+
+	if current := globalLimit.Load(); current != nil && current.config == c {
+		return
+	}
+
+	globalLimit.Store(&globalRateLimiter{
+		config:  c,
+		limiter: rate.NewLimiter(rate.Limit(c.MessagesPerSecond), c.MessagesPerSecond),
+	})
+}
+
+// wait blocks until a message can be written. It returns true if the
+// limit was exceeded and the caller should send the alert. If ctx is
+// cancelled we're draining the pipe, and the message is let through.
+func (g *globalRateLimiter) wait(ctx context.Context) bool {
+	delay := g.limiter.Reserve().Delay()
+	if delay == 0 {
+		return false
+	}
+
+	alert := false
+	now := time.Now()
+
+	g.lock.Lock()
+	if now.Sub(g.lastAlert) >= g.config.AlertInterval {
+		g.lastAlert = now
+		alert = true
+	}
+	g.lock.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+
+	return alert
+}
+
+// alert returns the alert message.
+func (g *globalRateLimiter) alert() string {
+	return fmt.Sprintf("Global rate limit of %d messages per second exceeded, pausing pipes", g.config.MessagesPerSecond)
+}
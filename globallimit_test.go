@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestSetGlobalRateLimitKeepsLimiter(t *testing.T) {
+	t.Cleanup(func() { globalLimit.Store(nil) })
+
+	c := globalRateLimitConfig{MessagesPerSecond: 100}
+
+	setGlobalRateLimit(c)
+	first := globalLimit.Load()
+	if first == nil {
+		t.Fatal("no limiter set")
+	}
+
+	setGlobalRateLimit(c)
+	if globalLimit.Load() != first {
+		t.Error("limiter replaced by the same configuration")
+	}
+
+	c.MessagesPerSecond = 200
+	setGlobalRateLimit(c)
+	if globalLimit.Load() == first {
+		t.Error("limiter kept after messages_per_second changed")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultHealthTimeout is how long a pipe can go without writing before
+// it's reported unhealthy.
+const defaultHealthTimeout = 60 * time.Second
+
+type healthConfig struct {
+	Address string        `toml:"address"`
+	Timeout time.Duration `toml:"health_timeout"`
+}
+
+// lastWrite holds the time of the last successful write of each running
+// pipe, keyed by path. Pipes are added when started, so a pipe that never
+// writes is reported unhealthy once the timeout has passed.
+var lastWrite sync.Map
+
+// checkHealth validates the [health] section.
+func checkHealth(h healthConfig) []error {
+	if h.Timeout < 0 {
+		return []error{errors.New("health_timeout can't be negative")}
+	}
+
+	return nil
+}
+
+// healthStarted records a pipe as running.
+func healthStarted(path string) {
+	lastWrite.Store(path, time.Now())
+}
+
+// healthStopped forgets a pipe that is no longer configured.
+func healthStopped(path string) {
+	lastWrite.Delete(path)
+}
+
+// healthWritten records a successful write by a pipe.
+func healthWritten(path string) {
+	lastWrite.Store(path, time.Now())
+}
+
+type healthStatus struct {
+	Status    string            `json:"status"`
+	Pipes     map[string]string `json:"pipes"`
+	Unhealthy []string          `json:"unhealthy,omitempty"`
+}
+
+// health returns the status of all running pipes. Pipes that didn't write
+// within timeout are unhealthy.
+func health(timeout time.Duration) healthStatus {
+	status := healthStatus{
+		Status: "ok",
+		Pipes:  make(map[string]string),
+	}
+
+	now := time.Now()
+	lastWrite.Range(func(key, value any) bool {
+		path := key.(string)
+		t := value.(time.Time)
+
+		status.Pipes[path] = t.Format(time.RFC3339)
+		if now.Sub(t) > timeout {
+			status.Unhealthy = append(status.Unhealthy, path)
+		}
+
+		return true
+	})
+
+	if len(status.Unhealthy) > 0 {
+		status.Status = "unhealthy"
+		sort.Strings(status.Unhealthy)
+	}
+
+	return status
+}
+
+// serveHealth serves the health of all pipes on /healthz. It responds 200
+// if all pipes are healthy and 503 otherwise. It only returns on error.
+func serveHealth(h healthConfig) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		status := health(timeout)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Logpipe-Version", Version)
+		if len(status.Unhealthy) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(w).Encode(status)
+	})
+
+	server := &http.Server{
+		Addr:    h.Address,
+		Handler: mux,
+	}
+
+	return server.ListenAndServe()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// hourlyCounter counts the lines of a pipe for the summary sent at the top
+// of each hour.
+type hourlyCounter struct {
+	count atomic.Int64
+}
+
+// newHourlyCounter returns the counter of a pipe, or nil if hourly_summary
+// is not set.
+func newHourlyCounter(p pipe) *hourlyCounter {
+	if !p.HourlySummary {
+		return nil
+	}
+
+	return &hourlyCounter{}
+}
+
+// add counts a line.
+func (h *hourlyCounter) add() {
+	if h != nil {
+		h.count.Add(1)
+	}
+}
+
+// run calls report with the number of lines counted at the top of each
+// hour, and resets the count, until stop is closed. The first report only
+// covers the time since the pipe started.
+func (h *hourlyCounter) run(stop <-chan struct{}, report func(count int64)) {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Hour).Add(time.Hour).Sub(now))
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			report(h.count.Swap(0))
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jsonRecord is a single line written by the JSON Lines output.
+type jsonRecord struct {
+	Time     string            `json:"time"`
+	Facility string            `json:"facility"`
+	Severity string            `json:"severity"`
+	Tag      string            `json:"tag"`
+	Message  string            `json:"message"`
+	Pipe     string            `json:"pipe"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// jsonFile is an output file shared by all pipes writing to the same path.
+// Writes are serialized by the lock.
+type jsonFile struct {
+	sync.Mutex
+	path string
+	fd   *os.File
+	refs int
+}
+
+// jsonFiles holds the open output files, keyed by path.
+var jsonFiles = struct {
+	sync.Mutex
+	files map[string]*jsonFile
+}{
+	files: make(map[string]*jsonFile),
+}
+
+// openJSONFile opens the output file at path, or returns the already opened
+// file. A path of "-" means stdout.
+func openJSONFile(path string) (*jsonFile, error) {
+	jsonFiles.Lock()
+	defer jsonFiles.Unlock()
+
+	f, found := jsonFiles.files[path]
+	if found {
+		f.refs++
+		return f, nil
+	}
+
+	fd := os.Stdout
+	if path != "-" {
+		var err error
+		fd, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f = &jsonFile{
+		path: path,
+		fd:   fd,
+		refs: 1,
+	}
+	jsonFiles.files[path] = f
+
+	return f, nil
+}
+
+func (f *jsonFile) write(line []byte) error {
+	f.Lock()
+	defer f.Unlock()
+
+	_, err := f.fd.Write(line)
+	return err
+}
+
+// release closes the file when the last pipe using it is done.
+func (f *jsonFile) release() error {
+	jsonFiles.Lock()
+	defer jsonFiles.Unlock()
+
+	f.refs--
+	if f.refs > 0 {
+		return nil
+	}
+
+	delete(jsonFiles.files, f.path)
+
+	if f.fd == os.Stdout {
+		return nil
+	}
+
+	return f.fd.Close()
+}
+
+// jsonLinesWriter writes messages from a pipe as JSON objects, one per line.
+type jsonLinesWriter struct {
+	file     *jsonFile
+	facility string
+	severity string
+	tag      string
+	pipe     string
+}
+
+func openJSONLines(p pipe, priority syslog.Priority) (*jsonLinesWriter, error) {
+	file, err := openJSONFile(p.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonLinesWriter{
+		file:     file,
+		facility: facilityName(priority),
+		severity: severityName(priority),
+		tag:      p.Tag,
+		pipe:     p.source(),
+	}, nil
+}
+
+func (w *jsonLinesWriter) Write(b []byte) (int, error) {
+	return w.WriteFields(b, nil)
+}
+
+func (w *jsonLinesWriter) WriteFields(b []byte, fields map[string]string) (int, error) {
+	line, err := json.Marshal(jsonRecord{
+		Time:     time.Now().UTC().Format(time.RFC3339Nano),
+		Facility: w.facility,
+		Severity: w.severity,
+		Tag:      w.tag,
+		Message:  strings.TrimSuffix(string(b), "\n"),
+		Pipe:     w.pipe,
+		Fields:   fields,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := w.file.write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (w *jsonLinesWriter) Close() error {
+	return w.file.release()
+}
+
+// facilityName returns the name of the facility of priority.
+func facilityName(priority syslog.Priority) string {
+	for name, facility := range facilities {
+		if facility == priority&^0x07 {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// severityName returns the name of the severity of priority.
+func severityName(priority syslog.Priority) string {
+	for name, severity := range severities {
+		if severity == priority&0x07 {
+			return name
+		}
+	}
+
+	return ""
+}
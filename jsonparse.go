@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"log/syslog"
+	"strings"
+)
+
+const (
+	defaultJSONMessageField  = "msg"
+	defaultJSONSeverityField = "level"
+)
+
+// jsonSeverityAliases maps common log level names to syslog severities.
+var jsonSeverityAliases = map[string]string{
+	"fatal":       "crit",
+	"critical":    "crit",
+	"error":       "err",
+	"warn":        "warning",
+	"information": "info",
+	"trace":       "debug",
+}
+
+// jsonParser extracts the message, severity and fields from JSON lines.
+type jsonParser struct {
+	messageField  string
+	severityField string
+}
+
+// jsonLine is a line parsed by jsonParser.
+type jsonLine struct {
+	message     string
+	severity    syslog.Priority
+	hasSeverity bool
+	fields      map[string]string
+}
+
+// newJSONParser returns a parser for the pipe, or nil if parse_json is not
+// enabled.
+func newJSONParser(p pipe) *jsonParser {
+	if !p.ParseJSON {
+		return nil
+	}
+
+	j := &jsonParser{
+		messageField:  p.JSONMessageField,
+		severityField: p.JSONSeverityField,
+	}
+
+	if j.messageField == "" {
+		j.messageField = defaultJSONMessageField
+	}
+
+	if j.severityField == "" {
+		j.severityField = defaultJSONSeverityField
+	}
+
+	return j
+}
+
+// parse parses line as a JSON object. It returns false if the line is not
+// an object with a string message field.
+func (j *jsonParser) parse(line string) (jsonLine, bool) {
+	var parsed jsonLine
+
+	if j == nil || !strings.HasPrefix(strings.TrimSpace(line), "{") {
+		return parsed, false
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &object); err != nil {
+		return parsed, false
+	}
+
+	if err := json.Unmarshal(object[j.messageField], &parsed.message); err != nil {
+		return parsed, false
+	}
+	delete(object, j.messageField)
+
+	var level string
+	if err := json.Unmarshal(object[j.severityField], &level); err == nil {
+		level = strings.ToLower(level)
+		if alias, found := jsonSeverityAliases[level]; found {
+			level = alias
+		}
+
+		parsed.severity, parsed.hasSeverity = severities[level]
+		if parsed.hasSeverity {
+			delete(object, j.severityField)
+		}
+	}
+
+	// Strings are used as is, anything else as JSON
+	parsed.fields = make(map[string]string, len(object))
+	for name, raw := range object {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+
+		parsed.fields[name] = value
+	}
+
+	return parsed, true
+}
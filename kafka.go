@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+const (
+	kafkaPartitionRoundRobin = "round_robin"
+	kafkaPartitionHash       = "hash"
+
+	kafkaSASLPlain       = "plain"
+	kafkaSASLSCRAMSHA256 = "scram-sha-256"
+	kafkaSASLSCRAMSHA512 = "scram-sha-512"
+)
+
+// kafkaWriter produces each message to a Kafka topic, keyed by tag.
+type kafkaWriter struct {
+	producer sarama.SyncProducer
+	topic    string
+	key      sarama.Encoder
+}
+
+// scramClient implements sarama.SCRAMClient using xdg-go/scram.
+type scramClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(username, password, authzID string) error {
+	client, err := c.hash.NewClient(username, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	c.conv = client.NewConversation()
+
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conv.Done()
+}
+
+// kafkaConfig returns the producer configuration for a pipe.
+func kafkaConfig(p pipe, tlsConfig *tls.Config) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.ClientID = "logpipe"
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+
+	if p.DialTimeout > 0 {
+		config.Net.DialTimeout = p.DialTimeout
+	}
+
+	switch p.KafkaPartitioner {
+	case kafkaPartitionRoundRobin, "":
+		config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	case kafkaPartitionHash:
+		config.Producer.Partitioner = sarama.NewHashPartitioner
+	default:
+		return nil, fmt.Errorf("unknown kafka_partitioner \"%s\"", p.KafkaPartitioner)
+	}
+
+	if tlsConfig != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if p.KafkaSASLMechanism != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.User = p.KafkaUsername
+		config.Net.SASL.Password = p.KafkaPassword
+	}
+
+	switch p.KafkaSASLMechanism {
+	case "":
+	case kafkaSASLPlain:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case kafkaSASLSCRAMSHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.HashGeneratorFcn(sha256.New)}
+		}
+	case kafkaSASLSCRAMSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.HashGeneratorFcn(sha512.New)}
+		}
+	default:
+		return nil, fmt.Errorf("unknown kafka_sasl_mechanism \"%s\"", p.KafkaSASLMechanism)
+	}
+
+	return config, nil
+}
+
+func dialKafka(p pipe, tlsConfig *tls.Config) (*kafkaWriter, error) {
+	config, err := kafkaConfig(p, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewSyncProducer(p.KafkaBrokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &kafkaWriter{
+		producer: producer,
+		topic:    p.KafkaTopic,
+	}
+
+	if p.Tag != "" {
+		w.key = sarama.StringEncoder(p.Tag)
+	}
+
+	return w, nil
+}
+
+// Write produces a message and waits for the broker to acknowledge it.
+func (w *kafkaWriter) Write(b []byte) (int, error) {
+	_, _, err := w.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: w.topic,
+		Key:   w.key,
+		Value: sarama.ByteEncoder(append([]byte(nil), b...)),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (w *kafkaWriter) Close() error {
+	return w.producer.Close()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+)
+
+// mergeLabels returns the global labels with the labels of a pipe added.
+// Labels of the pipe override global labels of the same name.
+func mergeLabels(global map[string]string, labels map[string]string) map[string]string {
+	if len(global) == 0 && len(labels) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]string, len(global)+len(labels))
+	maps.Copy(merged, global)
+	maps.Copy(merged, labels)
+
+	return merged
+}
+
+// checkLabels validates the label names of a pipe. Labels end up as RFC
+// 5424 structured data, so they're held to its rules for names.
+func checkLabels(p pipe) []error {
+	var errs []error
+
+	for name := range p.Labels {
+		if err := checkSDName(name); err != nil {
+			errs = append(errs, fmt.Errorf("%s has invalid label name: %s", p.source(), err.Error()))
+		}
+	}
+
+	return errs
+}
+
+// withLabels returns the fields of a message with the labels added.
+// Labels take precedence over fields parsed from the message, so a message
+// can't pass itself off as coming from elsewhere.
+func withLabels(fields map[string]string, labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return fields
+	}
+
+	merged := make(map[string]string, len(fields)+len(labels))
+	maps.Copy(merged, fields)
+	maps.Copy(merged, labels)
+
+	return merged
+}
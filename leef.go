@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	leefVersion1 = "1.0"
+	leefVersion2 = "2.0"
+
+	// leefTimeFormat is a devTime format QRadar recognizes without
+	// devTimeFormat
+	leefTimeFormat = "Jan 02 2006 15:04:05.000 MST"
+)
+
+var leefEscaper = strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// leefSource returns the first non-loopback address of this host, sent as
+// src. It's empty if there is none.
+var leefSource = sync.OnceValue(func() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+
+	for _, addr := range addrs {
+		if ip, ok := addr.(*net.IPNet); ok && !ip.IP.IsLoopback() {
+			return ip.IP.String()
+		}
+	}
+
+	return ""
+})
+
+// formatLEEF formats a message as a LEEF event with tab separated
+// attributes. The tag is the event id. LEEF 2.0 names the tab as delimiter
+// in the header, LEEF 1.0 always uses tabs.
+func formatLEEF(version, message string, priority syslog.Priority, tag string, now time.Time) string {
+	if version == "" {
+		version = leefVersion2
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "LEEF:%s|Logpipe|logpipe|%s|%s|",
+		version, cefHeaderEscaper.Replace(Version), cefHeaderEscaper.Replace(tag))
+	if version == leefVersion2 {
+		b.WriteString("x09|")
+	}
+
+	attributes := [][2]string{
+		{"devTime", now.UTC().Format(leefTimeFormat)},
+		{"sev", fmt.Sprint(cefSeverity(priority))},
+		{"src", leefSource()},
+		{"msg", message},
+	}
+
+	first := true
+	for _, attribute := range attributes {
+		if attribute[1] == "" {
+			continue
+		}
+
+		if !first {
+			b.WriteByte('\t')
+		}
+		first = false
+
+		b.WriteString(attribute[0])
+		b.WriteByte('=')
+		b.WriteString(leefEscaper.Replace(attribute[1]))
+	}
+
+	return b.String()
+}
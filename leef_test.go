@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/syslog"
+	"testing"
+	"time"
+)
+
+func TestFormatLEEF(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		version  string
+		source   string
+		message  string
+		priority syslog.Priority
+		tag      string
+		want     string
+	}{
+		{
+			name:     "2.0 by default",
+			source:   "192.0.2.10",
+			message:  "user bob logged in",
+			priority: syslog.LOG_LOCAL6 | syslog.LOG_INFO,
+			tag:      "login",
+			want:     "LEEF:2.0|Logpipe|logpipe|" + Version + "|login|x09|devTime=May 01 2024 12:30:00.000 UTC\tsev=3\tsrc=192.0.2.10\tmsg=user bob logged in",
+		},
+		{
+			name:     "1.0 has no delimiter field",
+			version:  leefVersion1,
+			source:   "192.0.2.10",
+			message:  "disk full",
+			priority: syslog.LOG_LOCAL6 | syslog.LOG_ERR,
+			tag:      "app",
+			want:     "LEEF:1.0|Logpipe|logpipe|" + Version + "|app|devTime=May 01 2024 12:30:00.000 UTC\tsev=7\tsrc=192.0.2.10\tmsg=disk full",
+		},
+		{
+			name:     "escaped tabs and newlines",
+			version:  leefVersion2,
+			source:   "192.0.2.10",
+			message:  "a\tb\nc",
+			priority: syslog.LOG_LOCAL6 | syslog.LOG_WARNING,
+			tag:      "app",
+			want:     "LEEF:2.0|Logpipe|logpipe|" + Version + `|app|x09|devTime=May 01 2024 12:30:00.000 UTC` + "\tsev=6\tsrc=192.0.2.10\t" + `msg=a\tb\nc`,
+		},
+		{
+			name:     "pipe in event id",
+			version:  leefVersion2,
+			message:  "started",
+			priority: syslog.LOG_LOCAL6 | syslog.LOG_INFO,
+			tag:      "a|b",
+			want:     "LEEF:2.0|Logpipe|logpipe|" + Version + `|a\|b|x09|devTime=May 01 2024 12:30:00.000 UTC` + "\tsev=3\tmsg=started",
+		},
+	}
+
+	previous := leefSource
+	t.Cleanup(func() { leefSource = previous })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			leefSource = func() string { return test.source }
+
+			if got := formatLEEF(test.version, test.message, test.priority, test.tag, now); got != test.want {
+				t.Errorf("got  %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
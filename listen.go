@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// listenTCP accepts connections on address until ctx is cancelled. The lines
+// of each connection are passed to a handler from newHandler.
+func listenTCP(ctx context.Context, address string, newHandler func() (func(string), func(), func())) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return serveListener(ctx, listener, newHandler)
+}
+
+// listenUnix accepts connections on a Unix domain socket at path until ctx is
+// cancelled. An existing socket at path is removed first. The socket is
+// removed again when the listener is closed.
+func listenUnix(ctx context.Context, path string, mode os.FileMode, newHandler func() (func(string), func(), func())) error {
+	fileInfo, err := os.Lstat(path)
+	if err == nil {
+		if fileInfo.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists, but it's not a socket", path)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return err
+	}
+
+	return serveListener(ctx, listener, newHandler)
+}
+
+// priHeader matches the PRI part of a syslog message.
+var priHeader = regexp.MustCompile(`^<[0-9]{1,3}>`)
+
+// listenUDP passes the payload of each datagram received on address to
+// handle until ctx is cancelled. Datagrams larger than maxSize are
+// truncated. If the payload starts with a syslog PRI header, it's stripped.
+func listenUDP(ctx context.Context, address string, maxSize int, handle func(string)) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// When we're asked to stop, we keep reading for a short while to drain
+	// datagrams already received
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now().Add(drainTimeout))
+	})
+	defer stop()
+
+	buffer := make([]byte, maxSize)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if n > 0 {
+			handle(priHeader.ReplaceAllString(string(buffer[:n]), ""))
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// serveListener accepts connections on listener until ctx is cancelled, and
+// waits for all connections to end before returning.
+func serveListener(ctx context.Context, listener net.Listener, newHandler func() (func(string), func(), func())) error {
+	stop := context.AfterFunc(ctx, func() {
+		listener.Close()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			listener.Close()
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readConn(ctx, conn, newHandler)
+		}()
+	}
+}
+
+// readConn passes each line read from conn to a handler until the client
+// closes the connection or ctx is cancelled.
+func readConn(ctx context.Context, conn net.Conn, newHandler func() (func(string), func(), func())) {
+	defer conn.Close()
+
+	handle, flush, done := newHandler()
+	defer done()
+	defer flush()
+
+	// When we're asked to stop, we keep reading for a short while to drain
+	// lines already sent
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now().Add(drainTimeout))
+	})
+	defer stop()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		handle(scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		logWarning("Reading from %s failed: %s", conn.RemoteAddr(), err.Error())
+	}
+}
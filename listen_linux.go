@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"net"
+)
+
+// abstractSockets is true if Unix domain sockets in the abstract namespace
+// can be listened on.
+const abstractSockets = true
+
+// listenAbstract accepts connections on a Unix domain socket in the abstract
+// namespace until ctx is cancelled. The socket has no file, so there's
+// nothing to remove before or after.
+func listenAbstract(ctx context.Context, name string, newHandler func() (func(string), func(), func())) error {
+	listener, err := net.Listen("unix", "\x00"+name)
+	if err != nil {
+		return err
+	}
+
+	return serveListener(ctx, listener, newHandler)
+}
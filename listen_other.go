@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// abstractSockets is false, the abstract namespace for Unix domain sockets
+// only exists on Linux.
+const abstractSockets = false
+
+// listenAbstract fails, abstract sockets are only supported on Linux.
+func listenAbstract(ctx context.Context, name string, newHandler func() (func(string), func(), func())) error {
+	return errors.New("abstract sockets are only supported on Linux")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	loggingStderr = "stderr"
+	loggingSyslog = "syslog"
+	loggingFile   = "file"
+
+	defaultLoggingFacility = "daemon"
+	defaultLoggingSeverity = "info"
+)
+
+// loggingConfig configures where logpipe's own messages go. Messages less
+// severe than severity are not logged.
+type loggingConfig struct {
+	Output   string `toml:"output"`
+	File     string `toml:"file"`
+	Facility string `toml:"facility"`
+	Severity string `toml:"severity"`
+}
+
+// logger writes logpipe's own messages. Until the configuration is read,
+// messages go to stderr.
+var logger = struct {
+	sync.Mutex
+	minimum syslog.Priority
+	writer  io.Writer
+	syslog  *syslog.Writer
+	file    *os.File
+}{
+	minimum: syslog.LOG_DEBUG,
+	writer:  os.Stderr,
+}
+
+// checkLogging returns all errors found in the [logging] section.
+func checkLogging(c loggingConfig) []error {
+	var errs []error
+
+	switch c.Output {
+	case "", loggingStderr, loggingSyslog:
+	case loggingFile:
+		if c.File == "" {
+			errs = append(errs, fmt.Errorf("[logging] must have file set to log to a file"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("[logging] has unknown output (%s)", c.Output))
+	}
+
+	if _, found := facilities[c.Facility]; c.Facility != "" && !found {
+		errs = append(errs, fmt.Errorf("[logging] has unknown facility (%s)", c.Facility))
+	}
+
+	if _, found := severities[c.Severity]; c.Severity != "" && !found {
+		errs = append(errs, fmt.Errorf("[logging] has unknown severity (%s)", c.Severity))
+	}
+
+	return errs
+}
+
+// configureLogging sends logpipe's own messages where configured. The
+// configuration must have been checked by checkLogging.
+func configureLogging(c loggingConfig) error {
+	facility := c.Facility
+	if facility == "" {
+		facility = defaultLoggingFacility
+	}
+
+	severity := c.Severity
+	if severity == "" {
+		severity = defaultLoggingSeverity
+	}
+
+	var writer io.Writer = os.Stderr
+	var syslogWriter *syslog.Writer
+	var file *os.File
+	var err error
+
+	switch c.Output {
+	case loggingSyslog:
+		syslogWriter, err = syslog.New(facilities[facility]|syslog.LOG_INFO, "logpipe")
+		if err != nil {
+			return fmt.Errorf("connecting to syslog for logging failed: %w", err)
+		}
+
+	case loggingFile:
+		file, err = os.OpenFile(c.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("opening log file failed: %w", err)
+		}
+		writer = file
+	}
+
+	logger.Lock()
+	defer logger.Unlock()
+
+	if logger.syslog != nil {
+		logger.syslog.Close()
+	}
+	if logger.file != nil {
+		logger.file.Close()
+	}
+
+	logger.minimum = severities[severity]
+	logger.writer = writer
+	logger.syslog = syslogWriter
+	logger.file = file
+
+	return nil
+}
+
+// logf logs a message at severity.
+func logf(severity syslog.Priority, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+
+	logger.Lock()
+	defer logger.Unlock()
+
+	if severity > logger.minimum {
+		return
+	}
+
+	if logger.syslog != nil {
+		var err error
+		switch {
+		case severity <= syslog.LOG_ERR:
+			err = logger.syslog.Err(message)
+		case severity == syslog.LOG_WARNING:
+			err = logger.syslog.Warning(message)
+		default:
+			err = logger.syslog.Info(message)
+		}
+
+		// Don't lose messages if syslog is unavailable
+		if err == nil {
+			return
+		}
+	}
+
+	if logger.file != nil {
+		message = time.Now().Format(time.RFC3339) + " " + message
+	}
+
+	fmt.Fprintln(logger.writer, message)
+}
+
+func logError(format string, args ...any) {
+	logf(syslog.LOG_ERR, format, args...)
+}
+
+func logWarning(format string, args ...any) {
+	logf(syslog.LOG_WARNING, format, args...)
+}
+
+func logInfo(format string, args ...any) {
+	logf(syslog.LOG_INFO, format, args...)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"log/syslog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// lokiLabelName matches valid Loki (Prometheus) label names.
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// lokiStream is a stream of entries sharing the same labels.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiPush is the payload for the Loki push API.
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+// lokiWriter pushes messages in batches to Loki.
+type lokiWriter struct {
+	*batcher
+	client   *http.Client
+	url      string
+	labels   map[string]string
+	username string
+	password string
+}
+
+func newLokiWriter(p pipe, priority syslog.Priority, tlsConfig *tls.Config) *lokiWriter {
+	labels := map[string]string{
+		"job":  "logpipe",
+		"pipe": p.source(),
+	}
+
+	if p.Tag != "" {
+		labels["tag"] = p.Tag
+	}
+
+	for name, value := range p.LokiLabels {
+		labels[name] = value
+	}
+
+	w := &lokiWriter{
+		client:   newHTTPClient(tlsConfig),
+		url:      strings.TrimSuffix(p.Address, "/") + "/loki/api/v1/push",
+		labels:   labels,
+		username: p.LokiUsername,
+		password: p.LokiPassword,
+	}
+	w.batcher = newBatcher("Loki", p.BatchSize, p.BatchTimeout, w.send, deadLetterDrop(p, priority))
+
+	return w
+}
+
+// send pushes a batch as a single stream. Timestamps are the time each
+// line was read, in nanoseconds since epoch.
+func (w *lokiWriter) send(batch []batchEntry) error {
+	stream := lokiStream{
+		Stream: w.labels,
+		Values: make([][2]string, 0, len(batch)),
+	}
+
+	for _, entry := range batch {
+		stream.Values = append(stream.Values, [2]string{
+			strconv.FormatInt(entry.time.UnixNano(), 10),
+			strings.TrimSuffix(entry.message, "\n"),
+		})
+	}
+
+	body, err := json.Marshal(lokiPush{Streams: []lokiStream{stream}})
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	if w.username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(w.username + ":" + w.password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+
+	return postHTTP(w.client, w.url, "application/json", header, body)
+}
@@ -0,0 +1,81 @@
+//go:build lua
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/go-lua"
+)
+
+// luaScript runs the transform function of a Lua script on each line. Each
+// pipe has its own Lua state, which is only used under the pipe's forward
+// lock, so scripts share no state between pipes.
+type luaScript struct {
+	path  string
+	state *lua.State
+}
+
+// newLuaScript loads the Lua script of the pipe, or returns nil if the pipe
+// doesn't use one. The script must define a global transform function.
+func newLuaScript(p pipe) (*luaScript, error) {
+	if p.LuaScript == "" {
+		return nil, nil
+	}
+
+	state := lua.NewState()
+	lua.OpenLibraries(state)
+
+	if err := lua.DoFile(state, p.LuaScript); err != nil {
+		return nil, fmt.Errorf("%s has invalid lua_script: %s", p.source(), err.Error())
+	}
+
+	state.Global("transform")
+	defined := state.IsFunction(-1)
+	state.Pop(1)
+
+	if !defined {
+		return nil, fmt.Errorf("%s has lua_script without a transform function (%s)", p.source(), p.LuaScript)
+	}
+
+	return &luaScript{
+		path:  p.LuaScript,
+		state: state,
+	}, nil
+}
+
+// transform passes the line to the transform function of the script. It
+// returns false if the function returned nil and the line should be
+// dropped. If the function fails, the line is passed on unchanged.
+func (s *luaScript) transform(line string) (string, bool) {
+	if s == nil {
+		return line, true
+	}
+
+	s.state.Global("transform")
+	s.state.PushString(strings.TrimSuffix(line, "\n"))
+
+	if err := s.state.ProtectedCall(1, 1, 0); err != nil {
+		s.state.Pop(1)
+		logWarning("Running transform of %s failed: %s", s.path, err.Error())
+		return line, true
+	}
+	defer s.state.Pop(1)
+
+	if s.state.IsNil(-1) {
+		return "", false
+	}
+
+	transformed, ok := s.state.ToString(-1)
+	if !ok {
+		logWarning("transform of %s returned neither a string nor nil", s.path)
+		return line, true
+	}
+
+	if transformed == "" {
+		return "", false
+	}
+
+	return transformed + "\n", true
+}
@@ -0,0 +1,23 @@
+//go:build !lua
+
+package main
+
+import "fmt"
+
+// luaScript is a Lua script run on each line. Lua support needs building
+// with -tags lua.
+type luaScript struct{}
+
+// newLuaScript returns nil, or an error if the pipe sets a Lua script.
+func newLuaScript(p pipe) (*luaScript, error) {
+	if p.LuaScript != "" {
+		return nil, fmt.Errorf("%s sets lua_script, but logpipe was built without Lua support", p.source())
+	}
+
+	return nil, nil
+}
+
+// transform returns the line unchanged.
+func (s *luaScript) transform(line string) (string, bool) {
+	return line, true
+}
@@ -0,0 +1,30 @@
+//go:build lua
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLuaScriptErrorKeepsStack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transform.lua")
+	if err := os.WriteFile(path, []byte(`function transform(line) error("broken") end`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newLuaScript(pipe{Path: "/tmp/app_log", LuaScript: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		if line, ok := s.transform("line\n"); line != "line\n" || !ok {
+			t.Errorf("transform returned %q, %v, want the line unchanged", line, ok)
+		}
+		if top := s.state.Top(); top != 0 {
+			t.Errorf("stack has %d values after a failing transform", top)
+		}
+	}
+}
@@ -1,19 +1,70 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
+	"io/fs"
 	"log/syslog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/coreos/go-systemd/v22/daemon"
 )
 
-const configPath = "/etc/logpipe.conf"
+var configPath = flag.String("config", "/etc/logpipe.conf", "Path to configuration file")
+var validate = flag.Bool("validate", false, "Validate the configuration file and exit")
+var dryRunFlag = flag.Bool("dry-run", false, "Print what would be done for each pipe and exit")
+var printConfigFlag = flag.Bool("print-config", false, "Print the effective configuration as TOML and exit")
+var schemaFlag = flag.Bool("schema", false, "Print a JSON Schema of the configuration file and exit")
+var pidfilePath = flag.String("pidfile", "/run/logpipe.pid", "Path to PID file locked while running, empty to disable")
+
+// Version is the version of logpipe, set when building with
+// -ldflags "-X main.Version=v1.2.3".
+var Version = "dev"
+
+// Exit codes
+const (
+	exitOK           = 0
+	exitConfigError  = 2
+	exitRuntimeError = 3
+	exitSignal       = 130
+)
+
+// stdinPath is the pipe path used to read from stdin instead of a named pipe.
+const stdinPath = "-"
+
+// defaultFifoMode is the permissions of named pipes created by logpipe
+// unless configured otherwise.
+const defaultFifoMode = 0666
+
+// defaultMaxMode is the most permissive mode of named pipes accepted
+// without warning unless configured otherwise.
+const defaultMaxMode = 0660
+
+// defaultSocketMode is the permissions of Unix domain sockets unless
+// configured otherwise.
+const defaultSocketMode = 0666
+
+// defaultUDPMaxSize is the maximum size of received UDP datagrams unless
+// configured otherwise.
+const defaultUDPMaxSize = 64 * 1024
+
+// defaultShutdownTimeout is how long we wait for pipes to drain on shutdown
+// unless configured otherwise.
+const defaultShutdownTimeout = 5 * time.Second
 
 var facilities = make(map[string]syslog.Priority)
 var severities = make(map[string]syslog.Priority)
@@ -31,8 +82,8 @@ facility = "local6"
 severity = "err"
 tag = "nginx"`
 
-	fmt.Printf("Write configuration file like this:\n---\n%s\n---\nsave in %s\n", conf, configPath)
-	os.Exit(1)
+	fmt.Printf("Write configuration file like this:\n---\n%s\n---\nsave in %s\n", conf, *configPath)
+	os.Exit(exitConfigError)
 }
 
 func init() {
@@ -74,116 +125,2001 @@ type pipe struct {
 	Facility string `toml:"facility"`
 	Severity string `toml:"severity"`
 	Tag      string `toml:"tag"`
+	Network  string `toml:"network"`
+	Address  string `toml:"address"`
+
+	// A text/template replacing the tag, see renderTags
+	TagTemplate string `toml:"tag_template"`
+
+	// The hostname sent in messages instead of the name of this host
+	Hostname string `toml:"hostname"`
+
+	// Local syslog sockets to send each message to, instead of the first
+	// of the usual ones found
+	SyslogSockets []string `toml:"syslog_sockets"`
+
+	// Where to send messages, "syslog" (default), "gelf", "jsonlines",
+	// "relp", "splunk_hec", "loki", "kafka", "fluentd", "otlp" or
+	// "datadog".
+	// OutputPath is the file written by "jsonlines", "-" means stdout. The
+	// output setting can also be a list of [[pipe.output]] tables to send
+	// messages to more than one output, see decodeOutputs
+	RawOutput   toml.Primitive `toml:"output"`
+	Output      string         `toml:"-"`
+	Outputs     []pipe         `toml:"-"`
+	OutputPath  string         `toml:"output_path"`
+	SplunkToken string         `toml:"splunk_token"`
+
+	// Datadog API key and site, like "datadoghq.eu"
+	DatadogAPIKey string `toml:"datadog_api_key"`
+	DatadogSite   string `toml:"datadog_site"`
+
+	// Protocol of the OTLP output. Only "http" is supported
+	OTLPProtocol string `toml:"otlp_protocol"`
+
+	// Loki stream labels and credentials
+	LokiLabels   map[string]string `toml:"loki_labels"`
+	LokiUsername string            `toml:"loki_username"`
+	LokiPassword string            `toml:"loki_password"`
+
+	// Kafka brokers, topic, partitioning and authentication
+	KafkaBrokers       []string `toml:"kafka_brokers"`
+	KafkaTopic         string   `toml:"kafka_topic"`
+	KafkaPartitioner   string   `toml:"kafka_partitioner"`
+	KafkaSASLMechanism string   `toml:"kafka_sasl_mechanism"`
+	KafkaUsername      string   `toml:"kafka_username"`
+	KafkaPassword      string   `toml:"kafka_password"`
+
+	// Number of messages queued between reading and writing, and what to
+	// do when the queue is full, see lineQueue
+	QueueDepth     int    `toml:"queue_depth"`
+	OverflowPolicy string `toml:"overflow_policy"`
+
+	// Batching of messages for HTTP outputs and syslog over TCP
+	BatchSize    int           `toml:"batch_size"`
+	BatchTimeout time.Duration `toml:"batch_timeout"`
+
+	// Permissions as an octal string and owner of the named pipe if it's
+	// created by logpipe
+	Mode string `toml:"mode"`
+	UID  *int   `toml:"uid"`
+	GID  *int   `toml:"gid"`
+
+	// The most permissive mode of the named pipe accepted at startup, see
+	// checkPermissions
+	MaxMode string `toml:"max_mode"`
+
+	// Maximum line length in bytes for named pipes and stdin. Longer lines
+	// are split
+	BufferSize int `toml:"buffer_size"`
+
+	// Number of times the pipe is restarted after failing before giving
+	// up, 0 means no limit
+	MaxRestarts int `toml:"max_restarts"`
+
+	// How long the pipe runs before it stops for good. TTLAction is
+	// "remove" (default) to also remove the named pipe, or "stop"
+	TTL       time.Duration `toml:"ttl"`
+	TTLAction string        `toml:"ttl_action"`
+
+	// Number of messages to buffer while reconnecting to a TCP syslog
+	ReconnectBuffer int `toml:"reconnect_buffer"`
+
+	// Stop reconnecting for OpenDuration (default 60s) after
+	// FailureThreshold consecutive failures, see circuitBreaker. 0 means
+	// the output is retried with backoff forever
+	FailureThreshold int           `toml:"failure_threshold"`
+	OpenDuration     time.Duration `toml:"open_duration"`
+
+	// How long to wait for connecting to a remote output, 0 means no
+	// limit
+	DialTimeout time.Duration `toml:"dial_timeout"`
+
+	// How long a write to a syslog connection can take before the
+	// connection is considered failed, 0 means no limit
+	WriteTimeout time.Duration `toml:"write_timeout"`
+
+	// How often to send a heartbeat message over an idle TCP syslog
+	// connection, 0 means never
+	KeepaliveInterval time.Duration `toml:"keepalive_interval"`
+
+	// TLS settings for remote syslog over TCP
+	TLSCert            string `toml:"tls_cert"`
+	TLSKey             string `toml:"tls_key"`
+	TLSCA              string `toml:"tls_ca"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+
+	// Maximum number of lines per second. Lines exceeding the limit are
+	// dropped or delayed depending on the policy
+	RateLimit       int    `toml:"rate_limit"`
+	RateLimitPolicy string `toml:"rate_limit_policy"`
+
+	// Prefix and suffix removed from lines, and whether to remove leading
+	// and trailing whitespace
+	TrimPrefix string `toml:"trim_prefix"`
+	TrimSuffix string `toml:"trim_suffix"`
+	TrimSpace  bool   `toml:"trim_space"`
+
+	// Take the tag from the start of each line, up to the first
+	// TagPrefixDelimiter. Lines without it use TagPrefixFallback
+	TagFromLinePrefix  bool   `toml:"tag_from_line_prefix"`
+	TagPrefixDelimiter string `toml:"tag_prefix_delimiter"`
+	TagPrefixFallback  string `toml:"tag_prefix_fallback"`
+
+	// Only forward lines matching (or not matching if inverted) a regular
+	// expression
+	FilterRegex  string `toml:"filter_regex"`
+	FilterInvert bool   `toml:"filter_invert"`
+
+	// Regular expression substitutions applied before forwarding
+	RewriteRegex string    `toml:"rewrite_regex"`
+	RewriteWith  string    `toml:"rewrite_with"`
+	Rewrite      []rewrite `toml:"rewrite"`
+
+	// Lua script defining a transform function, which is passed each line
+	// after rewriting and returns the line to forward, or nil to drop it.
+	// Needs logpipe built with -tags lua
+	LuaScript string `toml:"lua_script"`
+
+	// Severity of lines matching a regular expression, the first match
+	// wins
+	SeverityMap []severityRule `toml:"severity_map"`
+
+	// Tag of lines matching a regular expression instead of the tag of
+	// the pipe, the first match wins
+	Alias []aliasRule `toml:"alias"`
+
+	// Lines longer than MaxMessageSize bytes are truncated or split
+	// depending on OversizedPolicy. SplitSuffix is appended to each part
+	// with the part number and count
+	MaxMessageSize  int    `toml:"max_message_size"`
+	OversizedPolicy string `toml:"oversized_policy"`
+	SplitSuffix     string `toml:"split_suffix"`
+
+	// Identical messages within DedupeWindow are suppressed, and a summary
+	// with the number of repeats is sent when the window expires.
+	// DedupeCacheSize is the number of distinct messages remembered
+	DedupeWindow    time.Duration `toml:"dedup_window"`
+	DedupeCacheSize int           `toml:"dedup_cache_size"`
+
+	// Warn if no line was received for WatchdogTimeout. With WatchdogTag
+	// set, the warning is also sent to the output with that tag
+	WatchdogTimeout time.Duration `toml:"watchdog_timeout"`
+	WatchdogTag     string        `toml:"watchdog_tag"`
+
+	// Warn when the process in WriterPidfile is not running, checked every
+	// WriterCheckInterval. Reading continues regardless
+	WriterPidfile       string        `toml:"writer_pidfile"`
+	WriterCheckInterval time.Duration `toml:"writer_check_interval"`
+
+	// Only forward 1 in SampleRate lines. Lines at err severity or more
+	// severe, after the severity map, are always forwarded
+	SampleRate int `toml:"sample_rate"`
+
+	// Send the number of lines received in the last hour at the top of
+	// each hour, at notice severity
+	HourlySummary bool `toml:"hourly_summary"`
+
+	// Parse lines as JSON objects. The message and severity are taken from
+	// the given fields, other fields are sent as structured data
+	ParseJSON         bool   `toml:"parse_json"`
+	JSONMessageField  string `toml:"json_message_field"`
+	JSONSeverityField string `toml:"json_severity_field"`
+
+	// Parse lines in the W3C Extended Log Format, with the field names of
+	// the #Fields directive. The fields are sent as structured data
+	ParseW3CELF bool `toml:"parse_w3c_elf"`
+
+	// Parse access log lines in the Combined Log Format of Apache and
+	// nginx. The fields are sent as structured data, lines not in the
+	// format are forwarded as is and counted
+	ParseApacheCombined bool `toml:"parse_apache_combined"`
+
+	// Wrap messages in an event format for SIEMs, "cef" or "leef".
+	// LEEFVersion is the LEEF version, "1.0" or "2.0" (default)
+	OutputFormat string `toml:"output_format"`
+	LEEFVersion  string `toml:"leef_version"`
+
+	// Prepend the time to each message, formatted with TimestampFormat
+	// (default RFC 3339). Messages parsed as JSON get a timestamp field
+	// instead
+	PrependTimestamp bool   `toml:"prepend_timestamp"`
+	TimestampFormat  string `toml:"timestamp_format"`
+
+	// Message format, "rfc3164" (default) or "rfc5424". Structured data is
+	// only supported by RFC 5424
+	Format         string            `toml:"format"`
+	SDID           string            `toml:"sd_id"`
+	StructuredData map[string]string `toml:"structured_data"`
+
+	// Labels added to the fields of every message, on top of the global
+	// labels
+	Labels map[string]string `toml:"labels"`
+
+	// Framing of syslog messages over TCP as described in RFC 6587,
+	// "newline" (default) or "octet-count"
+	TCPFraming string `toml:"tcp_framing"`
+
+	// Join lines into a single message. A message starts with a line
+	// matching MultilineStart and is at most MultilineMaxLines long. With
+	// MultilineTimeout, a record also ends when no line was read for the
+	// timeout
+	MultilineStart    string        `toml:"multiline_start"`
+	MultilineMaxLines int           `toml:"multiline_max_lines"`
+	MultilineTimeout  time.Duration `toml:"multiline_timeout"`
+
+	// Accept connections on a TCP address or a Unix domain socket, or
+	// receive datagrams on a UDP address instead of reading a named pipe
+	ListenTCP  string `toml:"listen_tcp"`
+	ListenUnix string `toml:"listen_unix"`
+	ListenUDP  string `toml:"listen_udp"`
+
+	// Accept connections on a Unix domain socket in the abstract namespace,
+	// given without the leading NUL byte. Only supported on Linux
+	ListenUnixAbstract string `toml:"listen_unix_abstract"`
+
+	// Maximum size of UDP datagrams in bytes
+	UDPMaxSize int `toml:"udp_max_size"`
+
+	// Follow a regular file like tail -F instead of reading a named pipe
+	TailFile     string        `toml:"tail_file"`
+	TailInterval time.Duration `toml:"tail_interval"`
+
+	// Run a command and read its stdout instead of reading a named pipe.
+	// The command is restarted when it exits
+	Exec string `toml:"exec"`
+
+	// Permissions of the Unix domain socket as an octal string
+	SocketMode string `toml:"socket_mode"`
+
+	// What ends a line read from a named pipe, stdin or command, "lf"
+	// (default), "crlf", "nul", a hexadecimal byte like "0x1e", or "none"
+	// to pass each read as a line
+	LineDelimiter string `toml:"line_delimiter"`
+
+	// Kernel buffer size of the named pipe in bytes, only used on Linux
+	PipeKernelBuffer int `toml:"pipe_kernel_buffer"`
 }
 
-type config struct {
-	Pipe []pipe `toml:"pipe"`
+// source returns where the pipe reads from. It identifies the pipe in error
+// messages, metrics and when reloading.
+func (p pipe) source() string {
+	switch {
+	case p.ListenTCP != "":
+		return "tcp://" + p.ListenTCP
+	case p.ListenUnix != "":
+		return "unix://" + p.ListenUnix
+	case p.ListenUnixAbstract != "":
+		return "unix://@" + p.ListenUnixAbstract
+	case p.ListenUDP != "":
+		return "udp://" + p.ListenUDP
+	case p.TailFile != "":
+		return p.TailFile
+	case p.Exec != "":
+		return "exec://" + p.Exec
+	}
+
+	return p.Path
 }
 
-func listenPipe(pipe pipe, wg sync.WaitGroup) {
-	// Calculate priority
+// hostname returns the hostname to send in messages of the pipe.
+func (p pipe) hostname() string {
+	if p.Hostname != "" {
+		return p.Hostname
+	}
 
-	if pipe.Facility == "" {
-		fmt.Printf("Configuration error: %s has no facility set\n", pipe.Path)
-		printConfig()
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// isFifo returns true if the pipe reads from a named pipe.
+func (p pipe) isFifo() bool {
+	return p.ListenTCP == "" && p.ListenUnix == "" && p.ListenUnixAbstract == "" && p.ListenUDP == "" && p.TailFile == "" && p.Exec == "" && p.Path != stdinPath
+}
+
+// parseMode parses permissions written as an octal string like "0660". If
+// value is empty, def is returned.
+func parseMode(value string, def os.FileMode) (os.FileMode, error) {
+	if value == "" {
+		return def, nil
 	}
-	facility, found := facilities[pipe.Facility]
-	if !found {
-		fmt.Printf("Configuration error: %s has unknown facility (%s)\n", pipe.Path, pipe.Facility)
-		printConfig()
+
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode (%s)", value)
 	}
 
-	if pipe.Severity == "" {
-		fmt.Printf("Configuration error: %s has no severity set\n", pipe.Path)
-		printConfig()
+	return os.FileMode(mode), nil
+}
+
+// socketMode returns the permissions for a Unix domain socket.
+func (p pipe) socketMode() (os.FileMode, error) {
+	mode, err := parseMode(p.SocketMode, defaultSocketMode)
+	if err != nil {
+		return 0, fmt.Errorf("%s has invalid socket_mode (%s)", p.source(), p.SocketMode)
 	}
-	severity, found := severities[pipe.Severity]
-	if !found {
-		fmt.Printf("Configuration error: %s has unknown severity (%s)\n", pipe.Path, pipe.Severity)
-		printConfig()
+
+	return mode, nil
+}
+
+// fifoMode returns the permissions for a named pipe created by logpipe.
+func (p pipe) fifoMode() (os.FileMode, error) {
+	mode, err := parseMode(p.Mode, defaultFifoMode)
+	if err != nil {
+		return 0, fmt.Errorf("%s has invalid mode (%s)", p.source(), p.Mode)
 	}
 
-	priority := facility | severity
+	return mode, nil
+}
 
-	// Check if pipe already exists
-	pipeExists := false
-	fileInfo, err := os.Stat(pipe.Path)
+// owner returns the owner for a named pipe created by logpipe. -1 means the
+// id should not be changed.
+func (p pipe) owner() (int, int) {
+	uid, gid := -1, -1
+	if p.UID != nil {
+		uid = *p.UID
+	}
+	if p.GID != nil {
+		gid = *p.GID
+	}
 
-	if err == nil {
-		if (fileInfo.Mode() & os.ModeNamedPipe) > 0 {
-			pipeExists = true
-		} else {
-			fmt.Printf("%d != %d\n", os.ModeNamedPipe, fileInfo.Mode())
-			panic(pipe.Path + " exists, but it's not a named pipe (FIFO)")
-		}
+	return uid, gid
+}
+
+// syslogDefaults are applied to pipes that don't set the fields themselves.
+type syslogDefaults struct {
+	Facility string `toml:"facility"`
+	Severity string `toml:"severity"`
+	Network  string `toml:"network"`
+	Address  string `toml:"address"`
+	TLSCert  string `toml:"tls_cert"`
+	TLSKey   string `toml:"tls_key"`
+	TLSCA    string `toml:"tls_ca"`
+	Hostname string `toml:"hostname"`
+
+	SyslogSockets []string `toml:"syslog_sockets"`
+}
+
+// apply returns p with the defaults applied. The default network, address
+// and TLS settings belong together, and are only applied if the pipe
+// doesn't set its own network or address.
+func (d syslogDefaults) apply(p pipe) pipe {
+	if p.Facility == "" {
+		p.Facility = d.Facility
 	}
 
-	// Try to create pipe if needed
-	if !pipeExists {
-		err := syscall.Mkfifo(pipe.Path, 0666)
-		if err != nil {
-			panic(err.Error())
+	if p.Severity == "" {
+		p.Severity = d.Severity
+	}
+
+	if p.Hostname == "" {
+		p.Hostname = d.Hostname
+	}
+
+	if len(p.SyslogSockets) == 0 {
+		p.SyslogSockets = d.SyslogSockets
+	}
+
+	if p.Network == "" && p.Address == "" {
+		p.Network = d.Network
+		p.Address = d.Address
+
+		if p.TLSCert == "" {
+			p.TLSCert = d.TLSCert
+		}
+		if p.TLSKey == "" {
+			p.TLSKey = d.TLSKey
+		}
+		if p.TLSCA == "" {
+			p.TLSCA = d.TLSCA
 		}
 	}
 
-	// Open pipe for reading
-	fd, err := os.Open(pipe.Path)
-	if err != nil {
-		panic(err.Error())
+	return p
+}
+
+type config struct {
+	// Glob patterns of files to read more pipes from. Relative patterns
+	// are relative to the directory of the including file
+	Include []string `toml:"include"`
+
+	// Where the configuration is read from, "file" (default), "consul"
+	// to read it from ConsulKey in the Consul KV store at ConsulAddress,
+	// or "etcd" to read it from EtcdKey at EtcdEndpoints. The
+	// configuration is reloaded when the key changes
+	ConfigBackend string   `toml:"config_backend"`
+	ConsulAddress string   `toml:"consul_address"`
+	ConsulKey     string   `toml:"consul_key"`
+	EtcdEndpoints []string `toml:"etcd_endpoints"`
+	EtcdKey       string   `toml:"etcd_key"`
+	EtcdTLSCert   string   `toml:"etcd_tls_cert"`
+	EtcdTLSKey    string   `toml:"etcd_tls_key"`
+	EtcdTLSCA     string   `toml:"etcd_tls_ca"`
+
+	// Referencing an unset or empty environment variable in a pipe is an
+	// error, see expandEnv
+	RequireEnv bool `toml:"require_env"`
+
+	// Labels added to the messages of all pipes, like the environment or
+	// datacenter. Labels of a pipe override these
+	Labels map[string]string `toml:"labels"`
+
+	Pipe    []pipe         `toml:"pipe"`
+	Syslog  syslogDefaults `toml:"syslog"`
+	Metrics metricsConfig  `toml:"metrics"`
+	Health  healthConfig   `toml:"health"`
+	Logging loggingConfig  `toml:"logging"`
+
+	// Records of the configuration logpipe runs with, on startup and
+	// reload
+	Audit auditConfig `toml:"audit"`
+
+	// Limit of the messages written by all pipes together
+	GlobalRateLimit globalRateLimitConfig `toml:"global_rate_limit"`
+
+	// CEF severities (0 to 10) of syslog severities, used for CEF and LEEF
+	// events. Severities not listed keep the default mapping
+	CEFSeverityMap map[string]int `toml:"cef_severity_map"`
+
+	// The user and group to run as after creating the named pipes
+	Security securityConfig `toml:"security"`
+
+	// Named pipe receiving messages that couldn't be delivered, as JSON
+	// lines
+	DeadLetterPath string `toml:"dead_letter_path"`
+
+	// Refuse to start if a named pipe is more permissive than its max_mode
+	StrictPermissions bool `toml:"strict_permissions"`
+
+	// How long to wait for pipes to drain on shutdown
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"`
+
+	// How named pipes are read, "goroutine" (default) or "epoll", see
+	// muxReader. ReaderWorkers is the number of workers for "epoll"
+	ReaderMode    string `toml:"reader_mode"`
+	ReaderWorkers int    `toml:"reader_workers"`
+}
+
+// checkPipe validates the configuration of a single pipe. It returns all
+// errors found.
+func checkPipe(p pipe) []error {
+	var errs []error
+
+	inputs := 0
+	for _, input := range []string{p.Path, p.ListenTCP, p.ListenUnix, p.ListenUnixAbstract, p.ListenUDP, p.TailFile, p.Exec} {
+		if input != "" {
+			inputs++
+		}
+	}
+	if inputs != 1 {
+		errs = append(errs, fmt.Errorf("%s must have exactly one of path, listen_tcp, listen_unix, listen_unix_abstract, listen_udp, tail_file or exec set", p.source()))
+	}
+	if p.ListenUnixAbstract != "" && !abstractSockets {
+		errs = append(errs, fmt.Errorf("%s sets listen_unix_abstract, which is only supported on Linux", p.source()))
+	}
+	if p.Exec != "" && strings.TrimSpace(p.Exec) == "" {
+		errs = append(errs, fmt.Errorf("%s has empty exec", p.source()))
 	}
-	defer fd.Close()
-	reader := bufio.NewReader(fd)
 
-	// Open connection to local syslog
-	log, err := syslog.New(priority, pipe.Tag)
+	if _, err := parseDelimiter(p.LineDelimiter); err != nil {
+		errs = append(errs, fmt.Errorf("%s has %w", p.source(), err))
+	}
+	if p.LineDelimiter != "" && (p.ListenTCP != "" || p.ListenUnix != "" || p.ListenUnixAbstract != "" || p.ListenUDP != "" || p.TailFile != "") {
+		errs = append(errs, fmt.Errorf("%s can only use line_delimiter with path or exec", p.source()))
+	}
 
-	// Loop forever
-	for {
-		message, err := reader.ReadString(0xa)
-		if err != nil && err != io.EOF {
-			panic("Reading from pipe failed: " + err.Error())
+	parsers := 0
+	for _, parse := range []bool{p.ParseJSON, p.ParseW3CELF, p.ParseApacheCombined} {
+		if parse {
+			parsers++
 		}
+	}
+	if parsers > 1 {
+		errs = append(errs, fmt.Errorf("%s can only use one of parse_json, parse_w3c_elf and parse_apache_combined", p.source()))
+	}
 
-		if message != "" {
-			_, err = log.Write([]byte(message))
-			if err != nil {
-				panic("Writing to syslog failed: " + err.Error())
-			}
+	if p.ParseW3CELF && (p.ListenTCP != "" || p.ListenUnix != "" || p.ListenUnixAbstract != "" || p.ListenUDP != "") {
+		errs = append(errs, fmt.Errorf("%s can only use parse_w3c_elf with path, tail_file or exec", p.source()))
+	}
+
+	if p.TailInterval < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative tail_interval (%s)", p.source(), p.TailInterval))
+	}
+
+	if p.MaxRestarts < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative max_restarts (%d)", p.source(), p.MaxRestarts))
+	}
+
+	if p.TTL < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative ttl (%s)", p.source(), p.TTL))
+	}
+	switch p.TTLAction {
+	case "", ttlRemove, ttlStop:
+	default:
+		errs = append(errs, fmt.Errorf("%s has unknown ttl_action (%s)", p.source(), p.TTLAction))
+	}
+	if p.TTLAction != "" && p.TTL == 0 {
+		errs = append(errs, fmt.Errorf("%s sets ttl_action without ttl", p.source()))
+	}
+
+	if p.BufferSize < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative buffer_size (%d)", p.source(), p.BufferSize))
+	}
+
+	if p.UDPMaxSize < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative udp_max_size (%d)", p.source(), p.UDPMaxSize))
+	}
+
+	if _, err := p.socketMode(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseMode(p.MaxMode, defaultMaxMode); err != nil {
+		errs = append(errs, fmt.Errorf("%s has invalid max_mode (%s)", p.source(), p.MaxMode))
+	}
+
+	if _, err := p.fifoMode(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if p.Facility == "" {
+		errs = append(errs, fmt.Errorf("%s has no facility set", p.source()))
+	} else if _, found := facilities[p.Facility]; !found {
+		errs = append(errs, fmt.Errorf("%s has unknown facility (%s)", p.source(), p.Facility))
+	}
+
+	if p.Severity == "" {
+		errs = append(errs, fmt.Errorf("%s has no severity set", p.source()))
+	} else if _, found := severities[p.Severity]; !found {
+		errs = append(errs, fmt.Errorf("%s has unknown severity (%s)", p.source(), p.Severity))
+	}
+
+	if len(p.Outputs) == 0 {
+		errs = append(errs, checkOutput(p)...)
+	}
+	for i, output := range p.Outputs {
+		for _, err := range checkOutput(output) {
+			errs = append(errs, fmt.Errorf("output %d of %w", i+1, err))
 		}
 	}
 
-	wg.Done()
-}
+	if p.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative rate_limit (%d)", p.source(), p.RateLimit))
+	}
+	switch p.RateLimitPolicy {
+	case "", "drop", "delay":
+	default:
+		errs = append(errs, fmt.Errorf("%s has unknown rate_limit_policy (%s)", p.source(), p.RateLimitPolicy))
+	}
 
-func main() {
-	var config config
+	if _, err := newLineFilter(p); err != nil {
+		errs = append(errs, err)
+	}
 
-	// Read the configuration file
-	if _, err := toml.DecodeFile(configPath, &config); err != nil {
-		printConfig()
+	if _, err := newRewriter(p); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := newMultiline(p); err != nil {
+		errs = append(errs, err)
 	}
 
-	// We use a waitgroup to avoid the application exiting
-	var wg sync.WaitGroup
+	if _, err := newLuaScript(p); err != nil {
+		errs = append(errs, err)
+	}
 
-	// Start a worker for each pipe
-	for _, pipe := range config.Pipe {
-		wg.Add(1)
-		go listenPipe(pipe, wg)
+	if _, err := newSeverityMap(p); err != nil {
+		errs = append(errs, err)
 	}
 
-	// This is a disgusting hack to keep logpipe running without doing anything
-	// It can be usefull for automated systems that expect a process to always be running
-	if len(config.Pipe) == 0 {
-		go func() {
-			for {
-				time.Sleep(time.Hour)
+	if _, err := newAliases(p); err != nil {
+		errs = append(errs, err)
+	}
+
+	if !p.TagFromLinePrefix && (p.TagPrefixDelimiter != "" || p.TagPrefixFallback != "") {
+		errs = append(errs, fmt.Errorf("%s sets tag_prefix_delimiter or tag_prefix_fallback without tag_from_line_prefix", p.source()))
+	}
+
+	if p.TimestampFormat != "" && !p.PrependTimestamp {
+		errs = append(errs, fmt.Errorf("%s sets timestamp_format without prepend_timestamp", p.source()))
+	}
+
+	if p.QueueDepth < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative queue_depth (%d)", p.source(), p.QueueDepth))
+	}
+	switch p.OverflowPolicy {
+	case "", overflowBlock, overflowDropOldest, overflowDropNewest:
+	default:
+		errs = append(errs, fmt.Errorf("%s has unknown overflow_policy (%s)", p.source(), p.OverflowPolicy))
+	}
+	if p.OverflowPolicy != "" && p.QueueDepth == 0 {
+		errs = append(errs, fmt.Errorf("%s sets overflow_policy without queue_depth", p.source()))
+	}
+
+	if p.FailureThreshold < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative failure_threshold (%d)", p.source(), p.FailureThreshold))
+	}
+	if p.OpenDuration < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative open_duration (%s)", p.source(), p.OpenDuration))
+	}
+	if p.OpenDuration != 0 && p.FailureThreshold == 0 {
+		errs = append(errs, fmt.Errorf("%s sets open_duration without failure_threshold", p.source()))
+	}
+	if p.FailureThreshold != 0 && !p.reconnects() && len(p.Outputs) == 0 {
+		errs = append(errs, fmt.Errorf("%s can only use failure_threshold with syslog over TCP without batching, Fluentd or [[pipe.output]]", p.source()))
+	}
+
+	if p.DialTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative dial_timeout (%s)", p.source(), p.DialTimeout))
+	}
+
+	if p.WriteTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative write_timeout (%s)", p.source(), p.WriteTimeout))
+	}
+
+	if p.PipeKernelBuffer < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative pipe_kernel_buffer (%d)", p.source(), p.PipeKernelBuffer))
+	}
+
+	if p.DedupeWindow < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative dedup_window (%s)", p.source(), p.DedupeWindow))
+	}
+	if p.DedupeCacheSize < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative dedup_cache_size (%d)", p.source(), p.DedupeCacheSize))
+	}
+
+	if p.SampleRate < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative sample_rate (%d)", p.source(), p.SampleRate))
+	}
+
+	if p.WriterCheckInterval < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative writer_check_interval (%s)", p.source(), p.WriterCheckInterval))
+	}
+	if p.WriterCheckInterval != 0 && p.WriterPidfile == "" {
+		errs = append(errs, fmt.Errorf("%s sets writer_check_interval without writer_pidfile", p.source()))
+	}
+
+	if p.WatchdogTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative watchdog_timeout (%s)", p.source(), p.WatchdogTimeout))
+	}
+	if p.WatchdogTag != "" && p.WatchdogTimeout == 0 {
+		errs = append(errs, fmt.Errorf("%s sets watchdog_tag without watchdog_timeout", p.source()))
+	}
+
+	if p.MaxMessageSize < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative max_message_size (%d)", p.source(), p.MaxMessageSize))
+	} else if _, err := newSizeLimit(p); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// checkOutput validates the output settings of a pipe, or of one of its
+// [[pipe.output]] tables.
+func checkOutput(p pipe) []error {
+	var errs []error
+
+	switch p.Output {
+	case "", outputSyslog:
+		// Network and address must be set together to use a remote
+		// syslog
+		if (p.Network == "") != (p.Address == "") {
+			errs = append(errs, fmt.Errorf("%s must have both network and address set to use remote syslog", p.source()))
+		}
+
+		if len(p.SyslogSockets) > 0 && p.Network != "" {
+			errs = append(errs, fmt.Errorf("%s can't use syslog_sockets with remote syslog", p.source()))
+		}
+		for _, socket := range p.SyslogSockets {
+			if !filepath.IsAbs(socket) {
+				errs = append(errs, fmt.Errorf("%s has syslog socket that is not an absolute path (%s)", p.source(), socket))
 			}
-		}()
+		}
+
+		switch p.TCPFraming {
+		case "", framingNewline:
+		case framingOctetCount:
+			if p.Network != "tcp" {
+				errs = append(errs, fmt.Errorf("%s can only use tcp_framing \"octet-count\" with network \"tcp\"", p.source()))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s has unknown tcp_framing (%s)", p.source(), p.TCPFraming))
+		}
+
+		if p.KeepaliveInterval < 0 {
+			errs = append(errs, fmt.Errorf("%s has negative keepalive_interval (%s)", p.source(), p.KeepaliveInterval))
+		}
+		if p.KeepaliveInterval > 0 && p.Network != "tcp" {
+			errs = append(errs, fmt.Errorf("%s can only use keepalive_interval with network \"tcp\"", p.source()))
+		}
+		if p.KeepaliveInterval > 0 && p.batchesSyslog() {
+			errs = append(errs, fmt.Errorf("%s can't use keepalive_interval with batching", p.source()))
+		}
+
+	case outputGELF:
+		if p.Address == "" {
+			errs = append(errs, fmt.Errorf("%s must have address set to use GELF", p.source()))
+		}
+		if p.Network != "" && p.Network != "udp" {
+			errs = append(errs, fmt.Errorf("%s can only use GELF with network \"udp\"", p.source()))
+		}
+
+	case outputRELP:
+		if p.Address == "" {
+			errs = append(errs, fmt.Errorf("%s must have address set to use RELP", p.source()))
+		}
+		if p.Network != "" && p.Network != "tcp" {
+			errs = append(errs, fmt.Errorf("%s can only use RELP with network \"tcp\"", p.source()))
+		}
+
+	case outputOTLP:
+		if !isHTTPAddress(p.Address) {
+			errs = append(errs, fmt.Errorf("%s must have an http:// or https:// address set to use OTLP", p.source()))
+		}
+		switch p.OTLPProtocol {
+		case "", otlpHTTP:
+		case otlpGRPC:
+			errs = append(errs, fmt.Errorf("%s uses otlp_protocol \"grpc\", only \"http\" is supported", p.source()))
+		default:
+			errs = append(errs, fmt.Errorf("%s has unknown otlp_protocol (%s)", p.source(), p.OTLPProtocol))
+		}
+
+	case outputFluentd:
+		if p.Address == "" {
+			errs = append(errs, fmt.Errorf("%s must have address set to use Fluentd", p.source()))
+		}
+		if p.Network != "" && p.Network != "tcp" {
+			errs = append(errs, fmt.Errorf("%s can only use Fluentd with network \"tcp\"", p.source()))
+		}
+
+	case outputSplunk:
+		if !isHTTPAddress(p.Address) {
+			errs = append(errs, fmt.Errorf("%s must have an http:// or https:// address set to use Splunk HEC", p.source()))
+		}
+		if p.SplunkToken == "" {
+			errs = append(errs, fmt.Errorf("%s must have splunk_token set to use Splunk HEC", p.source()))
+		}
+
+	case outputDatadog:
+		if p.DatadogAPIKey == "" {
+			errs = append(errs, fmt.Errorf("%s must have datadog_api_key set to use Datadog", p.source()))
+		}
+		if p.Address != "" && !isHTTPAddress(p.Address) {
+			errs = append(errs, fmt.Errorf("%s must have an http:// or https:// address to use Datadog", p.source()))
+		}
+		if strings.Contains(p.DatadogSite, "/") {
+			errs = append(errs, fmt.Errorf("%s has invalid datadog_site (%s)", p.source(), p.DatadogSite))
+		}
+
+	case outputLoki:
+		if !isHTTPAddress(p.Address) {
+			errs = append(errs, fmt.Errorf("%s must have an http:// or https:// address set to use Loki", p.source()))
+		}
+		for name := range p.LokiLabels {
+			if !lokiLabelName.MatchString(name) {
+				errs = append(errs, fmt.Errorf("%s has invalid Loki label name \"%s\"", p.source(), name))
+			}
+		}
+		if p.LokiPassword != "" && p.LokiUsername == "" {
+			errs = append(errs, fmt.Errorf("%s must have loki_username set to use loki_password", p.source()))
+		}
+
+	case outputKafka:
+		if len(p.KafkaBrokers) == 0 {
+			errs = append(errs, fmt.Errorf("%s must have kafka_brokers set to use Kafka", p.source()))
+		}
+		if p.KafkaTopic == "" {
+			errs = append(errs, fmt.Errorf("%s must have kafka_topic set to use Kafka", p.source()))
+		}
+		if p.KafkaSASLMechanism != "" && p.KafkaUsername == "" {
+			errs = append(errs, fmt.Errorf("%s must have kafka_username set to use SASL", p.source()))
+		}
+		if _, err := kafkaConfig(p, nil); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", p.source(), err.Error()))
+		}
+
+	case outputJSON:
+		if p.OutputPath == "" {
+			errs = append(errs, fmt.Errorf("%s must have output_path set to use JSON Lines", p.source()))
+		}
+
+	default:
+		errs = append(errs, fmt.Errorf("%s has unknown output (%s)", p.source(), p.Output))
+	}
+
+	if p.KeepaliveInterval != 0 && p.Output != "" && p.Output != outputSyslog {
+		errs = append(errs, fmt.Errorf("%s can only use keepalive_interval with syslog", p.source()))
+	}
 
-		select {}
+	switch p.OutputFormat {
+	case "", eventFormatCEF, eventFormatLEEF:
+	default:
+		errs = append(errs, fmt.Errorf("%s has unknown output_format (%s)", p.source(), p.OutputFormat))
 	}
 
-	// Wait for all workers
-	wg.Wait()
+	switch p.LEEFVersion {
+	case "", leefVersion1, leefVersion2:
+	default:
+		errs = append(errs, fmt.Errorf("%s has unknown leef_version (%s)", p.source(), p.LEEFVersion))
+	}
+	if p.LEEFVersion != "" && p.OutputFormat != eventFormatLEEF {
+		errs = append(errs, fmt.Errorf("%s sets leef_version without output_format \"leef\"", p.source()))
+	}
+
+	switch p.Format {
+	case "", formatRFC3164, formatRFC5424:
+	default:
+		errs = append(errs, fmt.Errorf("%s has unknown format (%s)", p.source(), p.Format))
+	}
+
+	if len(p.StructuredData) > 0 || p.SDID != "" {
+		if p.Format != formatRFC5424 {
+			errs = append(errs, fmt.Errorf("%s can only use structured data with format \"rfc5424\"", p.source()))
+		}
+
+		if p.SDID != "" {
+			if err := checkSDName(p.SDID); err != nil {
+				errs = append(errs, fmt.Errorf("%s has invalid sd_id: %s", p.source(), err.Error()))
+			}
+		}
+
+		for name := range p.StructuredData {
+			if err := checkSDName(name); err != nil {
+				errs = append(errs, fmt.Errorf("%s has invalid structured data name: %s", p.source(), err.Error()))
+			}
+		}
+	}
+
+	errs = append(errs, checkLabels(p)...)
+
+	if p.BatchSize < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative batch_size (%d)", p.source(), p.BatchSize))
+	}
+	if p.BatchTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s has negative batch_timeout (%s)", p.source(), p.BatchTimeout))
+	}
+
+	if p.usesTLS() {
+		if p.Network != "tcp" && !p.usesHTTP() && p.Output != outputKafka {
+			errs = append(errs, fmt.Errorf("%s can only use TLS with network \"tcp\", HTTP outputs or Kafka", p.source()))
+		}
+
+		if _, err := tlsConfig(p); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", p.source(), err.Error()))
+		}
+	}
+
+	return errs
+}
+
+// decodeConfig reads the configuration file and the files it includes,
+// and the pipes in the environment if enabled with LOGPIPE_CONFIG_ENV, and
+// applies the defaults to each pipe. It also returns the keys that were not
+// recognized.
+func decodeConfig(path string) (config, []string, error) {
+	config, undecoded, err := decodeFile(path, nil)
+
+	// The file only says where to read the configuration from
+	if err == nil && config.ConfigBackend != "" && config.ConfigBackend != backendFile {
+		config, undecoded, err = decodeBackend(config)
+	}
+
+	// With the configuration in the environment, the file is optional
+	if envConfigEnabled() {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+
+		if err == nil {
+			config, err = configFromEnv(config)
+		}
+	}
+	if err != nil {
+		return config, nil, err
+	}
+
+	for i, p := range config.Pipe {
+		for j, output := range p.Outputs {
+			p.Outputs[j] = config.Syslog.apply(output)
+		}
+		p = config.Syslog.apply(p)
+		p.Labels = mergeLabels(config.Labels, p.Labels)
+		config.Pipe[i] = p
+	}
+
+	if err := expandEnv(&config); err != nil {
+		return config, nil, err
+	}
+
+	if err := renderTags(&config); err != nil {
+		return config, nil, err
+	}
+
+	return config, undecoded, nil
+}
+
+// decodeFile decodes a configuration file, and appends the pipes of the
+// files it includes. including holds the files being decoded to detect
+// circular includes.
+func decodeFile(path string, including []string) (config, []string, error) {
+	var config config
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return config, nil, err
+	}
+
+	if slices.Contains(including, absPath) {
+		return config, nil, fmt.Errorf("%s is included circularly", path)
+	}
+	including = append(including, absPath)
+
+	meta, err := decodeFormat(path, &config)
+	if err != nil {
+		if len(including) > 1 {
+			err = fmt.Errorf("%s: %w", path, err)
+		}
+		return config, nil, err
+	}
+
+	// Included files can only add pipes
+	if len(including) > 1 {
+		for _, key := range meta.Keys() {
+			if key[0] != "pipe" && key[0] != "include" {
+				return config, nil, fmt.Errorf("%s: only pipes and include can be set in included files, not %s", path, key[0])
+			}
+		}
+	}
+
+	for i := range config.Pipe {
+		if err := decodeOutputs(meta, &config.Pipe[i]); err != nil {
+			return config, nil, err
+		}
+	}
+
+	var undecoded []string
+	for _, key := range meta.Undecoded() {
+		if len(including) > 1 {
+			undecoded = append(undecoded, path+": "+key.String())
+		} else {
+			undecoded = append(undecoded, key.String())
+		}
+	}
+
+	for _, pattern := range config.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return config, nil, fmt.Errorf("invalid include %s: %w", pattern, err)
+		}
+
+		for _, match := range matches {
+			included, includedUndecoded, err := decodeFile(match, including)
+			if err != nil {
+				return config, nil, err
+			}
+
+			config.Pipe = append(config.Pipe, included.Pipe...)
+			undecoded = append(undecoded, includedUndecoded...)
+		}
+	}
+
+	return config, undecoded, nil
+}
+
+// checkConfig returns all errors found in the configuration.
+func checkConfig(config config) []error {
+	var errs []error
+
+	stdin := 0
+	sources := make(map[string]bool)
+	for _, p := range config.Pipe {
+		errs = append(errs, checkPipe(p)...)
+
+		if p.Path == stdinPath {
+			stdin++
+		}
+
+		if sources[p.source()] {
+			errs = append(errs, fmt.Errorf("%s is configured more than once", p.source()))
+		}
+		sources[p.source()] = true
+	}
+
+	errs = append(errs, checkHealth(config.Health)...)
+	errs = append(errs, checkSecurity(config.Security)...)
+	errs = append(errs, checkReader(config)...)
+	errs = append(errs, checkLogging(config.Logging)...)
+	errs = append(errs, checkAudit(config.Audit)...)
+	errs = append(errs, checkConfigBackend(config)...)
+	errs = append(errs, checkGlobalRateLimit(config.GlobalRateLimit)...)
+	errs = append(errs, checkCEFSeverityMap(config.CEFSeverityMap)...)
+
+	if stdin > 1 {
+		errs = append(errs, errors.New("only one pipe can read from stdin"))
+	}
+
+	return errs
+}
+
+// readConfig reads and validates the configuration file.
+func readConfig(path string) (config, []error) {
+	config, _, err := decodeConfig(path)
+	if err != nil {
+		return config, []error{err}
+	}
+
+	return config, checkConfig(config)
+}
+
+// listenPipe forwards lines from a named pipe to syslog until ctx is
+// cancelled. The pipe must have been validated by checkPipe. An error is
+// returned if the pipe fails.
+func listenPipe(parent context.Context, p pipe) error {
+	// A failing output cancels ctx to stop reading
+	ctx, fail := context.WithCancelCause(parent)
+	defer fail(nil)
+
+	facility := facilities[p.Facility]
+	priority := facility | severities[p.Severity]
+
+	filter, err := newLineFilter(p)
+	if err != nil {
+		return err
+	}
+
+	rewriter, err := newRewriter(p)
+	if err != nil {
+		return err
+	}
+
+	script, err := newLuaScript(p)
+	if err != nil {
+		return err
+	}
+
+	severityMap, err := newSeverityMap(p)
+	if err != nil {
+		return err
+	}
+
+	aliases, err := newAliases(p)
+	if err != nil {
+		return err
+	}
+
+	sizeLimit, err := newSizeLimit(p)
+	if err != nil {
+		return err
+	}
+
+	jsonParser := newJSONParser(p)
+	w3cParser := newW3CParser(p)
+	apacheParser := newApacheParser(p)
+	dedupe := newDedupe(p)
+
+	// Open connection to the output. If no network is configured for
+	// syslog, we use the local syslog socket. Outputs for the severities
+	// of the severity map and tags taken from lines are opened when first
+	// needed
+	writers := newPriorityWriters(p)
+	defer writers.Close()
+
+	if _, err := writers.get(priority, p.Tag); err != nil {
+		return fmt.Errorf("connecting to %s failed: %w", p.destination(), err)
+	}
+
+	// Dropped lines are reported at warning severity
+	limiter := newRateLimiter(p)
+	if limiter != nil && p.RateLimitPolicy != "delay" {
+		warnings, err := openOutput(p, facility|syslog.LOG_WARNING)
+		if err != nil {
+			return fmt.Errorf("connecting to %s failed: %w", p.destination(), err)
+		}
+
+		stop := make(chan struct{})
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			limiter.report(stop, p.source(), warnings)
+		}()
+
+		defer func() {
+			close(stop)
+			<-done
+			warnings.Close()
+		}()
+	}
+
+	stats := statsFor(p.source())
+	stats.up.Store(1)
+	defer stats.up.Store(0)
+
+	// write writes a message to the output for priority and tag. Long
+	// messages are truncated or split first
+	write := func(message string, priority syslog.Priority, tag string, fields map[string]string) {
+		// Lines still read after the output failed are dropped
+		if context.Cause(ctx) != nil && parent.Err() == nil {
+			return
+		}
+
+		fields = withLabels(fields, p.Labels)
+
+		for _, message := range sizeLimit.apply(message) {
+			if limiter != nil && !limiter.allow(ctx) {
+				return
+			}
+
+			// The alert goes to the output of the pipe held back first
+			if global := globalLimit.Load(); global != nil && global.wait(ctx) {
+				logWarning("%s", global.alert())
+
+				alert, err := writers.get(facility|syslog.LOG_WARNING, global.config.AlertTag)
+				if err == nil {
+					_, err = alert.Write([]byte(global.alert()))
+				}
+				if err != nil {
+					logWarning("Sending rate limit alert to %s failed: %s", p.destination(), err.Error())
+				}
+			}
+
+			log, err := writers.get(priority, tag)
+			if err == nil {
+				_, err = writeFields(log, []byte(message), fields)
+			}
+			if err != nil {
+				stats.errors.Add(1)
+
+				writeDeadLetter(p, message, priority, tag, fields, err)
+
+				// UDP is lossy anyway. An unreachable host should not
+				// restart the pipe, so we only report the error
+				if !p.lossy() {
+					fail(fmt.Errorf("writing to %s failed: %w", p.destination(), err))
+					return
+				}
+				logWarning("Writing to %s failed: %s", p.destination(), err.Error())
+			} else {
+				stats.messages.Add(1)
+				stats.bytes.Add(int64(len(message)))
+				healthWritten(p.source())
+			}
+		}
+	}
+
+	// With a queue, messages are written from a separate goroutine so a
+	// slow output doesn't hold back reading. The queue is drained before
+	// the outputs are closed
+	queue := newLineQueue(p, stats)
+	if queue != nil {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			queue.run(write)
+		}()
+
+		defer func() {
+			queue.close()
+			<-done
+		}()
+	}
+
+	// deliver timestamps a message and writes or queues it
+	deliver := func(message string, priority syslog.Priority, tag string, fields map[string]string) {
+		if context.Cause(ctx) != nil && parent.Err() == nil {
+			return
+		}
+
+		message, fields = p.stamp(message, fields, time.Now())
+		message = p.formatEvent(message, priority, tag)
+
+		if queue != nil {
+			queue.push(queuedLine{message: message, priority: priority, tag: tag, fields: fields})
+			return
+		}
+
+		write(message, priority, tag, fields)
+	}
+
+	watchdog := newWatchdog(p)
+	hourly := newHourlyCounter(p)
+
+	// forward filters and rewrites a message before writing it to syslog.
+	// Listening inputs call it from multiple goroutines
+	var forwardLock sync.Mutex
+	var sampleCount int
+	forward := func(message string) {
+		watchdog.seen()
+		hourly.add()
+
+		forwardLock.Lock()
+		defer forwardLock.Unlock()
+
+		message, ok := p.trim(message)
+		if !ok || w3cParser.directive(message) {
+			return
+		}
+
+		message, tag := p.splitTag(message)
+
+		if !filter.pass(message) {
+			return
+		}
+
+		message, ok = rewriter.rewrite(message)
+		if !ok {
+			return
+		}
+
+		message, ok = script.transform(message)
+		if !ok {
+			return
+		}
+
+		severity := severityMap.severity(message, severities[p.Severity])
+		tag = aliases.tag(message, tag)
+
+		var fields map[string]string
+		if parsed, ok := jsonParser.parse(message); ok {
+			message = parsed.message
+			fields = parsed.fields
+			if parsed.hasSeverity {
+				severity = parsed.severity
+			}
+		} else if parsed, ok := w3cParser.parse(message); ok {
+			fields = parsed
+		} else if parsed, ok := apacheParser.parse(message); ok {
+			fields = parsed
+		} else if apacheParser != nil {
+			stats.unparsed.Add(1)
+		}
+
+		// Sampled lines are counted as forwarded
+		if p.SampleRate > 1 && severity > syslog.LOG_ERR {
+			sampleCount++
+			if sampleCount%p.SampleRate != 1 {
+				stats.messages.Add(1)
+				stats.sampled.Add(1)
+				return
+			}
+		}
+
+		priority := facility | severity
+		if !dedupe.check(message, priority, tag, fields, time.Now()) {
+			return
+		}
+
+		deliver(message, priority, tag, fields)
+	}
+
+	// Silence is reported from the watchdog's goroutine, which owns the
+	// timer
+	if watchdog != nil {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+
+			watchdog.run(stop, func(silence time.Duration) {
+				logWarning("No message received on %s for %s", p.source(), silence.Round(time.Second))
+
+				if p.WatchdogTag != "" {
+					forwardLock.Lock()
+					deliver(fmt.Sprintf("No message received for %s", silence.Round(time.Second)), facility|syslog.LOG_WARNING, p.WatchdogTag, nil)
+					forwardLock.Unlock()
+				}
+			})
+		}()
+
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+	if p.WriterPidfile != "" {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+
+			watchWriter(stop, p, func(err error) {
+				logWarning("Writer of %s is gone: %s", p.source(), err.Error())
+
+				forwardLock.Lock()
+				deliver(fmt.Sprintf("Writer is gone: %s", err.Error()), facility|syslog.LOG_WARNING, p.Tag, nil)
+				forwardLock.Unlock()
+			})
+		}()
+
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+	if hourly != nil {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+
+			hourly.run(stop, func(count int64) {
+				forwardLock.Lock()
+				deliver(fmt.Sprintf("%d messages in the last hour", count), facility|syslog.LOG_NOTICE, p.Tag, nil)
+				forwardLock.Unlock()
+			})
+		}()
+
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+	// Summaries of suppressed repeats are sent when the dedupe window
+	// expires, and when the pipe stops
+	if dedupe != nil {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+
+			ticker := time.NewTicker(dedupe.window)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-stop:
+					return
+				case now := <-ticker.C:
+					forwardLock.Lock()
+					for _, summary := range dedupe.expire(now) {
+						deliver(summary.message, summary.priority, summary.tag, summary.fields)
+					}
+					forwardLock.Unlock()
+				}
+			}
+		}()
+
+		defer func() {
+			close(stop)
+			<-done
+
+			for _, summary := range dedupe.flush() {
+				deliver(summary.message, summary.priority, summary.tag, summary.fields)
+			}
+		}()
+	}
+
+	// newHandler returns functions to handle lines, to flush the record
+	// being aggregated when a stream ends, and to release the handler when
+	// it's not used anymore. A named pipe reuses its handler for each
+	// writer, so flush can be called more than once
+	newHandler := func() (func(string), func(), func()) {
+		// The W3C field names only apply to the stream declaring them
+		end := func() {
+			forwardLock.Lock()
+			w3cParser.reset()
+			forwardLock.Unlock()
+		}
+
+		multiline, _ := newMultiline(p)
+		if multiline == nil {
+			return forward, end, func() {}
+		}
+
+		handle, flush, done := multiline.handlers(forward)
+
+		return handle, func() {
+			flush()
+			end()
+		}, done
+	}
+
+	err = readInput(ctx, p, newHandler)
+
+	if cause := context.Cause(ctx); cause != nil && parent.Err() == nil {
+		return cause
+	}
+
+	return err
+}
+
+// readInput reads lines from the input of a pipe until ctx is cancelled,
+// and passes them to the handlers returned by newHandler.
+func readInput(ctx context.Context, p pipe, newHandler func() (func(string), func(), func())) error {
+	stats := statsFor(p.source())
+
+	if p.ListenTCP != "" {
+		err := listenTCP(ctx, p.ListenTCP, newHandler)
+		if err != nil {
+			stats.errors.Add(1)
+			return fmt.Errorf("listening on %s failed: %w", p.ListenTCP, err)
+		}
+
+		return nil
+	}
+
+	if p.ListenUnix != "" {
+		mode, _ := p.socketMode()
+
+		err := listenUnix(ctx, p.ListenUnix, mode, newHandler)
+		if err != nil {
+			stats.errors.Add(1)
+			return fmt.Errorf("listening on %s failed: %w", p.ListenUnix, err)
+		}
+
+		return nil
+	}
+
+	if p.ListenUnixAbstract != "" {
+		err := listenAbstract(ctx, p.ListenUnixAbstract, newHandler)
+		if err != nil {
+			stats.errors.Add(1)
+			return fmt.Errorf("listening on @%s failed: %w", p.ListenUnixAbstract, err)
+		}
+
+		return nil
+	}
+
+	handle, flush, done := newHandler()
+	defer done()
+
+	if p.TailFile != "" {
+		interval := p.TailInterval
+		if interval == 0 {
+			interval = defaultTailInterval
+		}
+
+		err := tailFile(ctx, p.TailFile, interval, handle)
+		flush()
+
+		if err != nil {
+			stats.errors.Add(1)
+			return fmt.Errorf("reading %s failed: %w", p.TailFile, err)
+		}
+
+		return nil
+	}
+
+	if p.ListenUDP != "" {
+		maxSize := p.UDPMaxSize
+		if maxSize == 0 {
+			maxSize = defaultUDPMaxSize
+		}
+
+		err := listenUDP(ctx, p.ListenUDP, maxSize, handle)
+		flush()
+
+		if err != nil {
+			stats.errors.Add(1)
+			return fmt.Errorf("listening on %s failed: %w", p.ListenUDP, err)
+		}
+
+		return nil
+	}
+
+	if p.Exec != "" {
+		err := readCommand(ctx, p, handle, flush)
+		if err != nil {
+			stats.errors.Add(1)
+			return fmt.Errorf("running %s failed: %w", p.Exec, err)
+		}
+
+		return nil
+	}
+
+	if p.Path == stdinPath {
+		err := readPipe(ctx, os.Stdin, p.bufferSize(), p.splitLines(), handle)
+		flush()
+
+		if err != nil {
+			stats.errors.Add(1)
+			return fmt.Errorf("reading from stdin failed: %w", err)
+		}
+
+		return nil
+	}
+
+	// Pipes opened at startup are open for both reading and writing, so we
+	// never see the writer closing them
+	if fd := preopenedFifo(p.Path); fd != nil {
+		// Clear the deadline left by a previous run of the pipe
+		fd.SetReadDeadline(time.Time{})
+
+		err := readFifo(ctx, fd, p.bufferSize(), p.splitLines(), handle)
+		flush()
+
+		if err != nil {
+			stats.errors.Add(1)
+			return fmt.Errorf("reading from pipe failed: %w", err)
+		}
+
+		return nil
+	}
+
+	mode, _ := p.fifoMode()
+	uid, gid := p.owner()
+
+	// Read until cancelled. The pipe is reopened every time the writer
+	// closes it, and recreated if it was removed in the meantime
+	backoff := minBackoff
+	for first := true; ; first = false {
+		fd, err := openFifo(ctx, p.Path, mode, uid, gid)
+		if err != nil {
+			if first {
+				return err
+			}
+
+			stats.errors.Add(1)
+			logError("Reopening %s failed: %s", p.Path, err.Error())
+
+			backoff = sleepBackoff(ctx, backoff)
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			continue
+		}
+		backoff = minBackoff
+		p.tunePipe(fd)
+
+		err = readFifo(ctx, fd, p.bufferSize(), p.splitLines(), handle)
+		fd.Close()
+
+		// The writer closed the pipe or we're stopping. Either way the
+		// current record is complete
+		flush()
+
+		if err != nil {
+			stats.errors.Add(1)
+			return fmt.Errorf("reading from pipe failed: %w", err)
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		stats.reopens.Add(1)
+	}
+}
+
+const (
+	ttlRemove = "remove"
+	ttlStop   = "stop"
+)
+
+// worker is a running pipe.
+type worker struct {
+	pipe   pipe
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func startWorker(p pipe) *worker {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if p.TTL > 0 {
+		// A pipe with a ttl expires when the context does
+		ctx, cancel = context.WithTimeout(context.Background(), p.TTL)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	w := &worker{
+		pipe:   p,
+		cancel: cancel,
+	}
+
+	w.wg.Add(1)
+	go w.run(ctx)
+
+	return w
+}
+
+// run runs the pipe until ctx is cancelled. If the pipe fails, it's
+// restarted with exponential backoff, up to max_restarts times.
+func (w *worker) run(ctx context.Context) {
+	defer w.wg.Done()
+
+	stats := statsFor(w.pipe.source())
+	backoff := minBackoff
+
+	for restarts := 0; ; restarts++ {
+		started := time.Now()
+
+		err := w.listen(ctx)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			w.expire()
+			return
+		}
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		stats.errors.Add(1)
+
+		if w.pipe.MaxRestarts > 0 && restarts >= w.pipe.MaxRestarts {
+			logError("Pipe %s failed, giving up after %d restarts: %s", w.pipe.source(), restarts, err.Error())
+			return
+		}
+
+		// Start over with a short backoff if the pipe ran for a while
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+
+		stats.restarts.Add(1)
+		logError("Pipe %s failed, restarting in %s: %s", w.pipe.source(), backoff, err.Error())
+
+		backoff = sleepBackoff(ctx, backoff)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// expire stops the pipe for good after its ttl, and removes its named pipe
+// unless ttl_action is "stop". The worker is kept, so the pipe isn't
+// started again by a reload.
+func (w *worker) expire() {
+	logInfo("Pipe %s expired after %s", w.pipe.source(), w.pipe.TTL)
+	healthStopped(w.pipe.source())
+
+	if w.pipe.TTLAction == ttlStop || !w.pipe.isFifo() {
+		return
+	}
+
+	if _, found := preopened[filepath.Clean(w.pipe.Path)]; found {
+		return
+	}
+
+	if err := os.Remove(w.pipe.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logWarning("Removing expired pipe %s failed: %s", w.pipe.Path, err.Error())
+	}
+}
+
+// listen runs the pipe once. A panic is returned as an error, so a bug
+// affecting one pipe doesn't bring down the others.
+func (w *worker) listen(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	return listenPipe(ctx, w.pipe)
+}
+
+// stop stops the worker and waits for it to drain the pipe.
+func (w *worker) stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// pipeManager holds the running pipes, keyed by their source. Each pipe
+// has its own context, so pipes can be started and stopped one at a time
+// while the others keep running.
+type pipeManager struct {
+	workers map[string]*worker
+}
+
+func newPipeManager() *pipeManager {
+	return &pipeManager{workers: make(map[string]*worker)}
+}
+
+// add starts a pipe.
+func (m *pipeManager) add(p pipe) {
+	logInfo("Starting pipe %s", p.source())
+	healthStarted(p.source())
+	m.workers[p.source()] = startWorker(p)
+}
+
+// remove stops a pipe and waits for it to drain.
+func (m *pipeManager) remove(path string) {
+	w, found := m.workers[path]
+	if !found {
+		return
+	}
+
+	logInfo("Stopping pipe %s", path)
+	w.stop()
+	delete(m.workers, path)
+	healthStopped(path)
+}
+
+// stopAll stops all pipes. It returns false if they didn't stop within
+// timeout.
+func (m *pipeManager) stopAll(timeout time.Duration) bool {
+	for _, w := range m.workers {
+		w.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for _, w := range m.workers {
+			w.wg.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// reload applies a new configuration to the running pipes. Pipes that
+// didn't change keep running without interruption.
+func (m *pipeManager) reload(config config) {
+	wanted := make(map[string]pipe)
+	for _, p := range config.Pipe {
+		wanted[p.source()] = p
+	}
+
+	for path, w := range m.workers {
+		p, found := wanted[path]
+		if found && reflect.DeepEqual(p, w.pipe) {
+			continue
+		}
+
+		m.remove(path)
+	}
+
+	for path, p := range wanted {
+		if _, found := m.workers[path]; !found {
+			m.add(p)
+		}
+	}
+}
+
+// run runs the pipes of config until ctx is cancelled, reloading the
+// configuration on SIGHUP. It returns an error if the pipes didn't stop
+// within the shutdown timeout.
+func run(ctx context.Context, config config) error {
+	// Reload configuration on SIGHUP. Signals arriving while starting are
+	// handled once the pipes run. This also keeps logpipe running without
+	// any pipes configured, which can be useful for automated systems that
+	// expect a process to always be running
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	setGlobalRateLimit(config.GlobalRateLimit)
+	setCEFSeverityMap(config.CEFSeverityMap)
+	pipes := newPipeManager()
+	pipes.reload(config)
+
+	watchBackend(config)
+
+	// The audit log records the file each configuration was read from
+	loadedFile := readConfigFile(*configPath)
+	audit("startup", *configPath, nil, config, configFile{}, loadedFile)
+
+	notify(daemon.SdNotifyReady)
+	startWatchdog()
+
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-hup:
+			config = reloadConfig(pipes, config, &loadedFile)
+		}
+	}
+
+	notify(daemon.SdNotifyStopping)
+
+	timeout := config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	if !pipes.stopAll(timeout) {
+		return fmt.Errorf("pipes did not stop within %s", timeout)
+	}
+
+	return nil
+}
+
+// reloadConfig reads the configuration file again and applies it to the
+// running pipes. It returns the configuration in effect, which is current
+// if the file has errors.
+func reloadConfig(pipes *pipeManager, current config, loadedFile *configFile) config {
+	notify(daemon.SdNotifyReloading)
+	defer notify(daemon.SdNotifyReady)
+
+	newConfig, errs := readConfig(*configPath)
+	if len(errs) > 0 {
+		logError("Reloading configuration failed, keeping current configuration")
+		for _, err := range errs {
+			logError("Configuration error: %s", err.Error())
+		}
+		return current
+	}
+
+	if newConfig.Security != current.Security {
+		logWarning("Changes to [security] take effect when logpipe is restarted")
+	}
+
+	if newConfig.ReaderMode != current.ReaderMode || newConfig.ReaderWorkers != current.ReaderWorkers {
+		logWarning("Changes to reader_mode and reader_workers take effect when logpipe is restarted")
+	}
+
+	if !newConfig.sameBackend(current) {
+		logWarning("Changes to config_backend and its settings take effect when logpipe is restarted")
+	}
+
+	if !reflect.DeepEqual(newConfig.Logging, current.Logging) {
+		if err := configureLogging(newConfig.Logging); err != nil {
+			logError("%s, keeping current logging", err.Error())
+		}
+	}
+
+	newFile := readConfigFile(*configPath)
+	audit("reload", *configPath, current.Pipe, newConfig, *loadedFile, newFile)
+	*loadedFile = newFile
+
+	setGlobalRateLimit(newConfig.GlobalRateLimit)
+	setCEFSeverityMap(newConfig.CEFSeverityMap)
+	if err := setDeadLetter(newConfig.DeadLetterPath); err != nil {
+		logError("%s, keeping current dead letter pipe", err.Error())
+	}
+	pipes.reload(newConfig)
+	logInfo("Reloaded configuration from %s", *configPath)
+
+	return newConfig
+}
+
+func main() {
+	flag.Parse()
+
+	// Read the configuration file
+	if *validate {
+		os.Exit(validateConfig(*configPath))
+	}
+
+	if *dryRunFlag {
+		os.Exit(dryRun(*configPath))
+	}
+
+	if *printConfigFlag {
+		os.Exit(printEffectiveConfig(*configPath))
+	}
+
+	if *schemaFlag {
+		os.Exit(printSchema())
+	}
+
+	config, errs := readConfig(*configPath)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			logError("Configuration error: %s", err.Error())
+		}
+		printConfig()
+	}
+
+	if err := configureLogging(config.Logging); err != nil {
+		logError("%s", err.Error())
+		os.Exit(exitRuntimeError)
+	}
+
+	logInfo("Starting logpipe %s", Version)
+
+	// Make sure we're the only logpipe managing the pipes
+	var pidfile *lockedPidfile
+	if *pidfilePath != "" {
+		var err error
+		pidfile, err = lockPidfile(*pidfilePath)
+		if err != nil {
+			logError("%s", err.Error())
+			os.Exit(exitRuntimeError)
+		}
+	}
+
+	if config.Metrics.Address != "" {
+		go func() {
+			err := serveMetrics(config.Metrics.Address)
+			logError("Serving metrics failed: %s", err.Error())
+			os.Exit(exitRuntimeError)
+		}()
+	}
+
+	if config.Health.Address != "" {
+		go func() {
+			err := serveHealth(config.Health)
+			logError("Serving health checks failed: %s", err.Error())
+			os.Exit(exitRuntimeError)
+		}()
+	}
+
+	// Pick up named pipes passed by systemd before starting any pipes
+	loadActivated()
+
+	// Create the named pipes while we may still be root, then drop
+	// privileges before anything is read. In a chroot the pipes must be
+	// opened before they're out of reach
+	if err := createFifos(config.Pipe); err != nil {
+		logError("%s", err.Error())
+		os.Exit(exitRuntimeError)
+	}
+
+	if err := checkPermissions(config); err != nil {
+		logError("%s", err.Error())
+		os.Exit(exitRuntimeError)
+	}
+
+	if err := setDeadLetter(config.DeadLetterPath); err != nil {
+		logError("%s", err.Error())
+		os.Exit(exitRuntimeError)
+	}
+
+	if config.Security.Chroot != "" {
+		if err := preopenFifos(config.Pipe); err != nil {
+			logError("%s", err.Error())
+			os.Exit(exitRuntimeError)
+		}
+	}
+
+	if err := enterChroot(config.Security); err != nil {
+		logError("%s", err.Error())
+		os.Exit(exitRuntimeError)
+	}
+
+	if err := dropPrivileges(config.Security); err != nil {
+		logError("Dropping privileges failed: %s", err.Error())
+		os.Exit(exitRuntimeError)
+	}
+
+	if config.ReaderMode == readerEpoll {
+		readers := config.ReaderWorkers
+		if readers == 0 {
+			readers = runtime.NumCPU()
+		}
+
+		var err error
+		mux, err = newMuxReader(readers)
+		if err != nil {
+			logError("Starting reader failed: %s", err.Error())
+			os.Exit(exitRuntimeError)
+		}
+	}
+
+	// Shut down on SIGTERM and SIGINT
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	var sig os.Signal
+	go func() {
+		sig = <-signals
+		cancel()
+	}()
+
+	err := run(ctx, config)
+
+	if pidfile != nil {
+		removePidfile(pidfile)
+	}
+
+	if err != nil {
+		logError("%s, exiting anyway", err.Error())
+		os.Exit(exitRuntimeError)
+	}
+
+	// SIGTERM is the normal way to stop a service
+	if sig == syscall.SIGINT {
+		os.Exit(exitSignal)
+	}
 }
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeSyslog is a syslog server on a Unix domain socket, passing each
+// message it receives to lines.
+type fakeSyslog struct {
+	path     string
+	listener net.Listener
+	lines    chan string
+}
+
+func newFakeSyslog(t *testing.T, dir string) *fakeSyslog {
+	t.Helper()
+
+	path := filepath.Join(dir, "log")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeSyslog{
+		path:     path,
+		listener: listener,
+		lines:    make(chan string, 100),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					s.lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+
+	return s
+}
+
+// expect waits for a message with the tag and text, skipping others.
+func (s *fakeSyslog) expect(t *testing.T, priority int, tag string, text string) {
+	t.Helper()
+
+	prefix := fmt.Sprintf("<%d>", priority)
+	suffix := fmt.Sprintf(" %s[%d]: %s", tag, os.Getpid(), text)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-s.lines:
+			if strings.HasPrefix(line, prefix) && strings.HasSuffix(line, suffix) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no message %s...%s received", prefix, suffix)
+		}
+	}
+}
+
+// writeFifo writes lines to the named pipe at path and closes it again,
+// like a process logging to it would.
+func writeFifo(t *testing.T, path string, lines ...string) {
+	t.Helper()
+
+	fd, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	for _, line := range lines {
+		if _, err := fd.WriteString(line + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeConfig writes a configuration with a single pipe from fifo to the
+// syslog socket.
+func writeConfig(t *testing.T, path string, fifo string, socket string, tag string) {
+	t.Helper()
+
+	conf := fmt.Sprintf(`
+[[pipe]]
+path = %q
+facility = "local6"
+severity = "info"
+tag = %q
+mode = "0600"
+syslog_sockets = [%q]
+`, fifo, tag, socket)
+
+	if err := os.WriteFile(path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	syslog := newFakeSyslog(t, dir)
+	fifo := filepath.Join(dir, "app_log")
+
+	path := filepath.Join(dir, "logpipe.conf")
+	writeConfig(t, path, fifo, syslog.path, "app")
+
+	previous := *configPath
+	*configPath = path
+	t.Cleanup(func() { *configPath = previous })
+
+	config, errs := readConfig(path)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if err := createFifos(config.Pipe); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, config)
+	}()
+
+	// local6.info is priority 182
+	writeFifo(t, fifo, "first", "second")
+	syslog.expect(t, 182, "app", "first")
+	syslog.expect(t, 182, "app", "second")
+
+	// A second writer is read like the first
+	writeFifo(t, fifo, "third")
+	syslog.expect(t, 182, "app", "third")
+
+	// On SIGHUP the pipe is restarted with the new tag. Lines written while
+	// the old pipe drains may still get the old tag
+	writeConfig(t, path, fifo, syslog.path, "reloaded")
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-reloaded:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			fd, err := os.OpenFile(fifo, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+			if err != nil {
+				continue
+			}
+			fd.WriteString("after reload\n")
+			fd.Close()
+		}
+	}()
+	syslog.expect(t, 182, "reloaded", "after reload")
+	close(reloaded)
+
+	// Lines written before shutting down are delivered
+	writeFifo(t, fifo, "last")
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run returned %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not return after cancelling")
+	}
+
+	syslog.expect(t, 182, "reloaded", "last")
+}
+
+func TestPipeManagerStopAllWaits(t *testing.T) {
+	m := newPipeManager()
+
+	w := &worker{cancel: func() {}}
+	w.wg.Add(1)
+	m.workers["/tmp/app_log"] = w
+
+	if m.stopAll(50 * time.Millisecond) {
+		t.Fatal("stopAll returned true with a worker still running")
+	}
+
+	go w.wg.Done()
+
+	if !m.stopAll(5 * time.Second) {
+		t.Fatal("stopAll returned false after the worker was done")
+	}
+}
+
+// Removing a pipe returns once its worker closed the named pipe, after
+// delivering the lines written before.
+func TestPipeManagerRemove(t *testing.T) {
+	dir := t.TempDir()
+	syslog := newFakeSyslog(t, dir)
+
+	path := filepath.Join(dir, "logpipe.conf")
+	writeConfig(t, path, filepath.Join(dir, "app_log"), syslog.path, "app")
+
+	config, errs := readConfig(path)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if err := createFifos(config.Pipe); err != nil {
+		t.Fatal(err)
+	}
+
+	p := config.Pipe[0]
+	m := newPipeManager()
+	m.add(p)
+
+	writeFifo(t, p.Path, "first")
+	syslog.expect(t, 182, "app", "first")
+
+	m.remove(p.source())
+
+	if _, found := m.workers[p.source()]; found {
+		t.Error("worker kept after removing the pipe")
+	}
+
+	// Without a reader, opening the named pipe for writing fails
+	fd, err := os.OpenFile(p.Path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err == nil {
+		fd.Close()
+		t.Error("named pipe still read after removing the pipe")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type metricsConfig struct {
+	Address string `toml:"address"`
+}
+
+// pipeStats holds the counters for a single pipe.
+type pipeStats struct {
+	messages atomic.Int64
+	bytes    atomic.Int64
+	errors   atomic.Int64
+	restarts atomic.Int64
+	reopens  atomic.Int64
+	queued   atomic.Int64
+	sampled  atomic.Int64
+	up       atomic.Int64
+
+	deadLettered atomic.Int64
+	unparsed     atomic.Int64
+
+	// Circuit breakers of the outputs, see circuitBreaker
+	breakersOpen atomic.Int64
+	breakerTrips atomic.Int64
+}
+
+// stats holds the counters for all pipes, keyed by path. Counters are kept
+// when a pipe is reloaded to keep them monotonic.
+var stats = struct {
+	sync.Mutex
+	pipes map[string]*pipeStats
+}{
+	pipes: make(map[string]*pipeStats),
+}
+
+// statsFor returns the counters for the pipe at path.
+func statsFor(path string) *pipeStats {
+	stats.Lock()
+	defer stats.Unlock()
+
+	s, found := stats.pipes[path]
+	if !found {
+		s = &pipeStats{}
+		stats.pipes[path] = s
+	}
+
+	return s
+}
+
+// metric describes a single per-pipe metric.
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value func(s *pipeStats) int64
+}
+
+var metrics = []metric{
+	{"logpipe_messages_total", "counter", "Number of messages forwarded.", func(s *pipeStats) int64 { return s.messages.Load() }},
+	{"logpipe_bytes_total", "counter", "Number of bytes forwarded.", func(s *pipeStats) int64 { return s.bytes.Load() }},
+	{"logpipe_errors_total", "counter", "Number of errors reading or forwarding messages.", func(s *pipeStats) int64 { return s.errors.Load() }},
+	{"logpipe_messages_sampled_total", "counter", "Number of messages not forwarded because of sampling.", func(s *pipeStats) int64 { return s.sampled.Load() }},
+	{"logpipe_messages_unparsed_total", "counter", "Number of messages forwarded as is because they couldn't be parsed.", func(s *pipeStats) int64 { return s.unparsed.Load() }},
+	{"logpipe_messages_dead_lettered_total", "counter", "Number of messages that failed to deliver and were written to the dead letter pipe.", func(s *pipeStats) int64 { return s.deadLettered.Load() }},
+	{"logpipe_restarts_total", "counter", "Number of times the pipe was restarted after failing.", func(s *pipeStats) int64 { return s.restarts.Load() }},
+	{"logpipe_fifo_reopens_total", "counter", "Number of times the named pipe was reopened after the writer closed it.", func(s *pipeStats) int64 { return s.reopens.Load() }},
+	{"logpipe_circuit_breaker_trips_total", "counter", "Number of times a circuit breaker of an output opened.", func(s *pipeStats) int64 { return s.breakerTrips.Load() }},
+	{"logpipe_circuit_breakers_open", "gauge", "Number of outputs with an open or half-open circuit breaker.", func(s *pipeStats) int64 { return s.breakersOpen.Load() }},
+	{"logpipe_queue_depth", "gauge", "Number of messages queued for writing.", func(s *pipeStats) int64 { return s.queued.Load() }},
+	{"logpipe_pipe_up", "gauge", "Whether the pipe is being read.", func(s *pipeStats) int64 { return s.up.Load() }},
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetrics writes all metrics in the Prometheus text format.
+func writeMetrics(w io.Writer) {
+	stats.Lock()
+	paths := make([]string, 0, len(stats.pipes))
+	for path := range stats.pipes {
+		paths = append(paths, path)
+	}
+	pipes := make(map[string]*pipeStats, len(stats.pipes))
+	for path, s := range stats.pipes {
+		pipes[path] = s
+	}
+	stats.Unlock()
+
+	sort.Strings(paths)
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+		for _, path := range paths {
+			fmt.Fprintf(w, "%s{pipe=\"%s\",version=\"%s\"} %d\n", m.name, labelEscaper.Replace(path), labelEscaper.Replace(Version), m.value(pipes[path]))
+		}
+	}
+}
+
+// serveMetrics serves metrics on /metrics. It only returns on error.
+func serveMetrics(address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+
+	server := &http.Server{
+		Addr:    address,
+		Handler: mux,
+	}
+
+	return server.ListenAndServe()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMultilineMaxLines bounds the memory used by a single record.
+const defaultMultilineMaxLines = 1000
+
+// multiline joins lines into records. A record starts with a line matching
+// a regular expression, and lasts until the next line matching the
+// expression, or until no line was added for the timeout.
+type multiline struct {
+	start    *regexp.Regexp
+	maxLines int
+	timeout  time.Duration
+	lines    []string
+
+	// last is when the last line was added
+	last time.Time
+}
+
+// newMultiline returns a multiline aggregator for the pipe, or nil if the
+// pipe doesn't use multiline aggregation.
+func newMultiline(p pipe) (*multiline, error) {
+	if p.MultilineStart == "" {
+		if p.MultilineTimeout != 0 {
+			return nil, fmt.Errorf("%s sets multiline_timeout without multiline_start", p.source())
+		}
+
+		return nil, nil
+	}
+
+	start, err := regexp.Compile(p.MultilineStart)
+	if err != nil {
+		return nil, fmt.Errorf("%s has invalid multiline_start: %s", p.source(), err.Error())
+	}
+
+	if p.MultilineMaxLines < 0 {
+		return nil, fmt.Errorf("%s has negative multiline_max_lines (%d)", p.source(), p.MultilineMaxLines)
+	}
+
+	if p.MultilineTimeout < 0 {
+		return nil, fmt.Errorf("%s has negative multiline_timeout (%s)", p.source(), p.MultilineTimeout)
+	}
+
+	maxLines := p.MultilineMaxLines
+	if maxLines == 0 {
+		maxLines = defaultMultilineMaxLines
+	}
+
+	return &multiline{
+		start:    start,
+		maxLines: maxLines,
+		timeout:  p.MultilineTimeout,
+	}, nil
+}
+
+// add adds a line and returns the records completed by it.
+func (m *multiline) add(line string) []string {
+	var records []string
+
+	line = strings.TrimSuffix(line, "\n")
+	if m.start.MatchString(line) && len(m.lines) > 0 {
+		records = append(records, m.flush())
+	}
+
+	m.lines = append(m.lines, line)
+	m.last = time.Now()
+	if len(m.lines) >= m.maxLines {
+		records = append(records, m.flush())
+	}
+
+	return records
+}
+
+// flush returns the current record, or an empty string if there are no
+// buffered lines.
+func (m *multiline) flush() string {
+	if len(m.lines) == 0 {
+		return ""
+	}
+
+	record := strings.Join(m.lines, "\n") + "\n"
+	m.lines = m.lines[:0]
+
+	return record
+}
+
+// handlers returns functions to add a line of a stream, to flush the record
+// being aggregated when a stream ends, and to stop once no more lines are
+// added. Completed records are passed to forward. Flushing doesn't stop
+// the aggregation, so the handlers can be used for the next stream. With a
+// timeout, a timer flushes the record when no line was added for the
+// timeout, so a record isn't held back until the next one starts.
+func (m *multiline) handlers(forward func(string)) (func(string), func(), func()) {
+	if m.timeout == 0 {
+		handle := func(line string) {
+			for _, record := range m.add(line) {
+				forward(record)
+			}
+		}
+
+		flush := func() {
+			if record := m.flush(); record != "" {
+				forward(record)
+			}
+		}
+
+		return handle, flush, func() {}
+	}
+
+	// lock is held while adding lines and flushing, so records are
+	// forwarded in order
+	var lock sync.Mutex
+
+	timer := time.NewTimer(m.timeout)
+	timer.Stop()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for {
+			select {
+			case <-timer.C:
+				lock.Lock()
+				// A line may have been added while the timer fired
+				if time.Since(m.last) >= m.timeout {
+					if record := m.flush(); record != "" {
+						forward(record)
+					}
+				}
+				lock.Unlock()
+
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	handle := func(line string) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		for _, record := range m.add(line) {
+			forward(record)
+		}
+
+		if len(m.lines) > 0 {
+			timer.Reset(m.timeout)
+		}
+	}
+
+	flush := func() {
+		lock.Lock()
+		defer lock.Unlock()
+
+		timer.Stop()
+		if record := m.flush(); record != "" {
+			forward(record)
+		}
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+			timer.Stop()
+		})
+	}
+
+	return handle, flush, release
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMultilineAdd(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxLines int
+		lines    []string
+		records  []string
+		rest     string
+	}{
+		{
+			name:    "joined until next start",
+			lines:   []string{"ERROR one", "  at a", "  at b", "ERROR two"},
+			records: []string{"ERROR one\n  at a\n  at b\n"},
+			rest:    "ERROR two\n",
+		},
+		{
+			name:  "continuation without start",
+			lines: []string{"  at a", "  at b"},
+			rest:  "  at a\n  at b\n",
+		},
+		{
+			name:     "max lines",
+			maxLines: 2,
+			lines:    []string{"ERROR one", "  at a", "  at b"},
+			records:  []string{"ERROR one\n  at a\n"},
+			rest:     "  at b\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := newMultiline(pipe{MultilineStart: "^ERROR", MultilineMaxLines: test.maxLines})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var records []string
+			for _, line := range test.lines {
+				records = append(records, m.add(line)...)
+			}
+
+			if !slices.Equal(records, test.records) {
+				t.Errorf("records %q, want %q", records, test.records)
+			}
+			if rest := m.flush(); rest != test.rest {
+				t.Errorf("flushed %q, want %q", rest, test.rest)
+			}
+		})
+	}
+}
+
+// recorder collects forwarded records.
+type recorder struct {
+	lock    sync.Mutex
+	records []string
+}
+
+func (r *recorder) forward(record string) {
+	r.lock.Lock()
+	r.records = append(r.records, record)
+	r.lock.Unlock()
+}
+
+func (r *recorder) get() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return slices.Clone(r.records)
+}
+
+// A named pipe flushes its handler each time a writer closes it, and keeps
+// using it for the next writer.
+func TestMultilineTimeoutAfterFlush(t *testing.T) {
+	m, err := newMultiline(pipe{MultilineStart: "^ERROR", MultilineTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r recorder
+	handle, flush, done := m.handlers(r.forward)
+	defer done()
+
+	handle("ERROR one")
+	flush()
+	handle("ERROR two")
+	flush()
+	flush()
+
+	handle("ERROR three")
+	handle("  at a")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(r.get()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	want := []string{"ERROR one\n", "ERROR two\n", "ERROR three\n  at a\n"}
+	if records := r.get(); !slices.Equal(records, want) {
+		t.Errorf("records %q, want %q", records, want)
+	}
+}
+
+func TestMultilineDoneTwice(t *testing.T) {
+	m, err := newMultiline(pipe{MultilineStart: "^ERROR", MultilineTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r recorder
+	handle, flush, done := m.handlers(r.forward)
+
+	handle("ERROR one")
+	flush()
+	done()
+	done()
+
+	if records := r.get(); !slices.Equal(records, []string{"ERROR one\n"}) {
+		t.Errorf("records %q", records)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	readerGoroutine = "goroutine"
+	readerEpoll     = "epoll"
+)
+
+// mux is the shared reader for named pipes with reader_mode = "epoll". It's
+// nil when each pipe is read by its own goroutine.
+var mux *muxReader
+
+// checkReader validates the reader settings of the configuration.
+func checkReader(config config) []error {
+	var errs []error
+
+	switch config.ReaderMode {
+	case "", readerGoroutine, readerEpoll:
+	default:
+		errs = append(errs, fmt.Errorf("unknown reader_mode (%s)", config.ReaderMode))
+	}
+
+	if config.ReaderWorkers < 0 {
+		errs = append(errs, fmt.Errorf("reader_workers can't be negative (%d)", config.ReaderWorkers))
+	}
+
+	return errs
+}
+
+// readFifo reads lines from a named pipe like readPipe, using the shared
+// reader if enabled.
+func readFifo(ctx context.Context, fd *os.File, bufferSize int, split bufio.SplitFunc, handle func(string)) error {
+	if mux != nil {
+		return mux.read(ctx, fd, bufferSize, split, handle)
+	}
+
+	return readPipe(ctx, fd, bufferSize, split, handle)
+}
+
+// muxFile is a named pipe read by muxReader.
+type muxFile struct {
+	// lock is held while reading, so lines are handled in order
+	lock sync.Mutex
+
+	fd      int
+	buf     []byte
+	maxSize int
+	split   bufio.SplitFunc
+	handle  func(string)
+	closed  bool
+	done    chan error
+}
+
+// muxReader waits for data on the named pipes of all pipes with epoll or
+// kqueue, and reads them from a pool of workers. Each named pipe is only
+// handed to one worker at a time.
+type muxReader struct {
+	poller *poller
+	jobs   chan *muxFile
+
+	lock  sync.Mutex
+	files map[int]*muxFile
+}
+
+func newMuxReader(workers int) (*muxReader, error) {
+	poller, err := newPoller()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &muxReader{
+		poller: poller,
+		jobs:   make(chan *muxFile, workers),
+		files:  make(map[int]*muxFile),
+	}
+
+	for range workers {
+		go m.work()
+	}
+	go m.wait()
+
+	return m, nil
+}
+
+// wait hands named pipes with data to the workers.
+func (m *muxReader) wait() {
+	fds := make([]int, 128)
+
+	for {
+		n, err := m.poller.wait(fds)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			logError("Waiting for named pipes failed: %s", err.Error())
+			return
+		}
+
+		for _, fd := range fds[:n] {
+			m.lock.Lock()
+			f := m.files[fd]
+			m.lock.Unlock()
+
+			if f != nil {
+				m.jobs <- f
+			}
+		}
+	}
+}
+
+func (m *muxReader) work() {
+	for f := range m.jobs {
+		f.lock.Lock()
+
+		if !f.closed {
+			done, err := f.readLines()
+			if !done {
+				err = m.poller.rearm(f.fd)
+				done = err != nil
+			}
+			if done {
+				m.finish(f, err)
+			}
+		}
+
+		f.lock.Unlock()
+	}
+}
+
+// finish stops reading f. It must be called with f locked.
+func (m *muxReader) finish(f *muxFile, err error) {
+	f.closed = true
+
+	m.lock.Lock()
+	delete(m.files, f.fd)
+	m.lock.Unlock()
+
+	m.poller.remove(f.fd)
+
+	f.done <- err
+}
+
+// read passes each line read from fd to handle until the writer closes the
+// pipe or ctx is cancelled, like readPipe.
+func (m *muxReader) read(ctx context.Context, fd *os.File, bufferSize int, split bufio.SplitFunc, handle func(string)) error {
+	conn, err := fd.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	f := &muxFile{
+		fd:      -1,
+		maxSize: bufferSize,
+		split:   split,
+		handle:  handle,
+		done:    make(chan error, 1),
+	}
+
+	err = conn.Control(func(fd uintptr) {
+		f.fd = int(fd)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := unix.SetNonblock(f.fd, true); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	m.files[f.fd] = f
+	m.lock.Unlock()
+
+	if err := m.poller.add(f.fd); err != nil {
+		m.lock.Lock()
+		delete(m.files, f.fd)
+		m.lock.Unlock()
+
+		return err
+	}
+
+	select {
+	case err := <-f.done:
+		return err
+	case <-ctx.Done():
+	}
+
+	// When we're asked to stop, we keep reading for a short while to drain
+	// lines already written to the pipe
+	select {
+	case <-f.done:
+		return nil
+	case <-time.After(drainTimeout):
+	}
+
+	f.lock.Lock()
+	if !f.closed {
+		m.finish(f, nil)
+	}
+	f.lock.Unlock()
+
+	return nil
+}
+
+// readLines reads what's available and passes the complete lines to
+// handle, split like readPipe does. It returns true when the writer closed
+// the pipe or reading failed.
+func (f *muxFile) readLines() (bool, error) {
+	chunk := make([]byte, min(initialBufferSize, f.maxSize))
+
+	n, err := unix.Read(f.fd, chunk)
+	if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+
+	// The writer closed the pipe. The split function decides what to do
+	// with a last line without delimiter
+	if n == 0 {
+		f.scan(true)
+		f.buf = nil
+
+		return true, nil
+	}
+
+	f.buf = append(f.buf, chunk[:n]...)
+	f.scan(false)
+
+	// Don't keep the consumed part of the buffer around
+	f.buf = append([]byte(nil), f.buf...)
+
+	return false, nil
+}
+
+// scan passes the lines in the buffer to handle and removes them.
+func (f *muxFile) scan(atEOF bool) {
+	for len(f.buf) > 0 {
+		advance, token, err := f.split(f.buf, atEOF)
+		if err != nil || advance == 0 {
+			return
+		}
+
+		if token != nil {
+			f.handle(string(token))
+		}
+		f.buf = f.buf[advance:]
+	}
+}
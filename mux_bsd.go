@@ -0,0 +1,61 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// poller waits for named pipes to become readable with kqueue. Named pipes
+// are disabled after each event, and must be rearmed after reading.
+type poller struct {
+	kq     int
+	events []unix.Kevent_t
+}
+
+func newPoller() (*poller, error) {
+	kq, err := unix.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	unix.CloseOnExec(kq)
+
+	return &poller{kq: kq}, nil
+}
+
+func (p *poller) change(fd int, flags int) error {
+	var event unix.Kevent_t
+	unix.SetKevent(&event, fd, unix.EVFILT_READ, flags)
+
+	_, err := unix.Kevent(p.kq, []unix.Kevent_t{event}, nil, nil)
+	return err
+}
+
+func (p *poller) add(fd int) error {
+	return p.change(fd, unix.EV_ADD|unix.EV_DISPATCH)
+}
+
+func (p *poller) rearm(fd int) error {
+	return p.change(fd, unix.EV_ENABLE|unix.EV_DISPATCH)
+}
+
+func (p *poller) remove(fd int) error {
+	return p.change(fd, unix.EV_DELETE)
+}
+
+// wait blocks until named pipes are readable, and stores their descriptors
+// in fds.
+func (p *poller) wait(fds []int) (int, error) {
+	if len(p.events) < len(fds) {
+		p.events = make([]unix.Kevent_t, len(fds))
+	}
+
+	n, err := unix.Kevent(p.kq, nil, p.events[:len(fds)], nil)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range n {
+		fds[i] = int(p.events[i].Ident)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// poller waits for named pipes to become readable with epoll. Named pipes
+// are added as one-shot, and must be rearmed after reading.
+type poller struct {
+	epfd   int
+	events []unix.EpollEvent
+}
+
+func newPoller() (*poller, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	return &poller{epfd: epfd}, nil
+}
+
+func (p *poller) event(fd int) *unix.EpollEvent {
+	return &unix.EpollEvent{
+		Events: unix.EPOLLIN | unix.EPOLLONESHOT,
+		Fd:     int32(fd),
+	}
+}
+
+func (p *poller) add(fd int) error {
+	return unix.EpollCtl(p.epfd, unix.EPOLL_CTL_ADD, fd, p.event(fd))
+}
+
+func (p *poller) rearm(fd int) error {
+	return unix.EpollCtl(p.epfd, unix.EPOLL_CTL_MOD, fd, p.event(fd))
+}
+
+func (p *poller) remove(fd int) error {
+	return unix.EpollCtl(p.epfd, unix.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait blocks until named pipes are readable, and stores their descriptors
+// in fds.
+func (p *poller) wait(fds []int) (int, error) {
+	if len(p.events) < len(fds) {
+		p.events = make([]unix.EpollEvent, len(fds))
+	}
+
+	n, err := unix.EpollWait(p.epfd, p.events[:len(fds)], -1)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range n {
+		fds[i] = int(p.events[i].Fd)
+	}
+
+	return n, nil
+}
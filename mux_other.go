@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "errors"
+
+// poller is not available on this platform.
+type poller struct{}
+
+func newPoller() (*poller, error) {
+	return nil, errors.New(`reader_mode "epoll" is not supported on this platform`)
+}
+
+func (p *poller) add(fd int) error            { return nil }
+func (p *poller) rearm(fd int) error          { return nil }
+func (p *poller) remove(fd int) error         { return nil }
+func (p *poller) wait(fds []int) (int, error) { return 0, nil }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log/syslog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	otlpHTTP = "http"
+	otlpGRPC = "grpc"
+)
+
+// otlpSeverities maps syslog severities to OpenTelemetry severity numbers.
+var otlpSeverities = map[syslog.Priority]int{
+	syslog.LOG_EMERG:   24, // FATAL4
+	syslog.LOG_ALERT:   22, // FATAL2
+	syslog.LOG_CRIT:    21, // FATAL
+	syslog.LOG_ERR:     17, // ERROR
+	syslog.LOG_WARNING: 13, // WARN
+	syslog.LOG_NOTICE:  10, // INFO2
+	syslog.LOG_INFO:    9,  // INFO
+	syslog.LOG_DEBUG:   5,  // DEBUG
+}
+
+// The OTLP/JSON encoding of ExportLogsServiceRequest, with only the fields
+// we send.
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpRecord struct {
+	TimeUnixNano         string          `json:"timeUnixNano"`
+	ObservedTimeUnixNano string          `json:"observedTimeUnixNano"`
+	SeverityNumber       int             `json:"severityNumber"`
+	SeverityText         string          `json:"severityText"`
+	Body                 otlpValue       `json:"body"`
+	Attributes           []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope    `json:"scope"`
+	LogRecords []otlpRecord `json:"logRecords"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpExport struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+// otlpWriter exports messages in batches to an OpenTelemetry collector with
+// OTLP/HTTP, using the JSON encoding. The tag is the instrumentation scope.
+type otlpWriter struct {
+	*batcher
+	client *http.Client
+	url    string
+
+	resource otlpResource
+	scope    otlpScope
+	severity int
+	text     string
+	facility string
+}
+
+func newOTLPWriter(p pipe, priority syslog.Priority, tlsConfig *tls.Config) *otlpWriter {
+	w := &otlpWriter{
+		client: newHTTPClient(tlsConfig),
+		url:    strings.TrimSuffix(p.Address, "/") + "/v1/logs",
+		resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "host.name", Value: otlpValue{StringValue: p.hostname()}},
+			{Key: "service.name", Value: otlpValue{StringValue: "logpipe"}},
+			{Key: "logpipe.pipe", Value: otlpValue{StringValue: p.source()}},
+		}},
+		scope:    otlpScope{Name: p.Tag},
+		severity: otlpSeverities[priority&0x07],
+		text:     severityName(priority),
+		facility: facilityName(priority),
+	}
+	w.batcher = newBatcher("OTLP", p.BatchSize, p.BatchTimeout, w.send, deadLetterDrop(p, priority))
+
+	return w
+}
+
+func (w *otlpWriter) WriteFields(b []byte, fields map[string]string) (int, error) {
+	w.enqueue(string(b), fields)
+
+	return len(b), nil
+}
+
+// send exports a batch as log records of a single scope. Fields become
+// attributes of the record.
+func (w *otlpWriter) send(batch []batchEntry) error {
+	scopeLogs := otlpScopeLogs{
+		Scope:      w.scope,
+		LogRecords: make([]otlpRecord, 0, len(batch)),
+	}
+
+	for _, entry := range batch {
+		t := strconv.FormatInt(entry.time.UnixNano(), 10)
+
+		attributes := []otlpAttribute{
+			{Key: "syslog.facility", Value: otlpValue{StringValue: w.facility}},
+		}
+
+		names := make([]string, 0, len(entry.fields))
+		for name := range entry.fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			attributes = append(attributes, otlpAttribute{Key: name, Value: otlpValue{StringValue: entry.fields[name]}})
+		}
+
+		scopeLogs.LogRecords = append(scopeLogs.LogRecords, otlpRecord{
+			TimeUnixNano:         t,
+			ObservedTimeUnixNano: t,
+			SeverityNumber:       w.severity,
+			SeverityText:         w.text,
+			Body:                 otlpValue{StringValue: strings.TrimSuffix(entry.message, "\n")},
+			Attributes:           attributes,
+		})
+	}
+
+	body, err := json.Marshal(otlpExport{ResourceLogs: []otlpResourceLogs{{
+		Resource:  w.resource,
+		ScopeLogs: []otlpScopeLogs{scopeLogs},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	return postHTTP(w.client, w.url, "application/json", nil, body)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"log/syslog"
+	"net"
+	"time"
+)
+
+const (
+	outputSyslog  = "syslog"
+	outputGELF    = "gelf"
+	outputJSON    = "jsonlines"
+	outputRELP    = "relp"
+	outputSplunk  = "splunk_hec"
+	outputLoki    = "loki"
+	outputKafka   = "kafka"
+	outputFluentd = "fluentd"
+	outputOTLP    = "otlp"
+	outputDatadog = "datadog"
+)
+
+// defaultTCPKeepAlive is the TCP keep-alive period of connections to pipes
+// without keepalive_interval.
+const defaultTCPKeepAlive = 30 * time.Second
+
+// dialOutput opens the output configured for a pipe.
+func dialOutput(p pipe, priority syslog.Priority, tlsConfig *tls.Config) (io.WriteCloser, error) {
+	switch p.Output {
+	case outputGELF:
+		return dialGELF(p, priority)
+	case outputJSON:
+		return openJSONLines(p, priority)
+	case outputRELP:
+		return dialRELPWriter(p, priority)
+	case outputSplunk:
+		return newSplunkWriter(p, priority, tlsConfig), nil
+	case outputDatadog:
+		return newDatadogWriter(p, priority, tlsConfig), nil
+	case outputLoki:
+		return newLokiWriter(p, priority, tlsConfig), nil
+	case outputKafka:
+		return dialKafka(p, tlsConfig)
+	case outputFluentd:
+		return dialFluentd(p, priority)
+	case outputOTLP:
+		return newOTLPWriter(p, priority, tlsConfig), nil
+	}
+
+	return dialSyslog(p, priority, tlsConfig)
+}
+
+// fieldWriter is implemented by outputs that can send fields along with a
+// message, like RFC 5424 structured data.
+type fieldWriter interface {
+	WriteFields(b []byte, fields map[string]string) (int, error)
+}
+
+// writeFields writes a message with fields to w. Outputs not supporting
+// fields only get the message.
+func writeFields(w io.Writer, b []byte, fields map[string]string) (int, error) {
+	if fw, ok := w.(fieldWriter); ok && len(fields) > 0 {
+		return fw.WriteFields(b, fields)
+	}
+
+	return w.Write(b)
+}
+
+// openOutput connects to the output of a pipe. TCP connections are re-dialed
+// in the background if they drop.
+func openOutput(p pipe, priority syslog.Priority) (io.WriteCloser, error) {
+	if len(p.Outputs) > 0 {
+		return openFanout(p.Outputs, priority)
+	}
+
+	dial, err := outputDialer(p, priority)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.reconnects() {
+		return newReconnectWriter(p, writer, dial, deadLetterDrop(p, priority)), nil
+	}
+
+	return writer, nil
+}
+
+// reconnects returns true if the output of the pipe is re-dialed by a
+// reconnectWriter when it fails. Batched syslog connections are re-dialed
+// by the batch writer.
+func (p pipe) reconnects() bool {
+	return (p.Network == "tcp" && !p.batchesSyslog()) || p.Output == outputFluentd
+}
+
+// dialer returns the dialer for connecting to the output of a pipe. TCP
+// keep-alive probes are sent at the keepalive interval of the pipe, so
+// dead connections are noticed even if the pipe is idle.
+func (p pipe) dialer() *net.Dialer {
+	keepalive := p.KeepaliveInterval
+	if keepalive <= 0 {
+		keepalive = defaultTCPKeepAlive
+	}
+
+	return &net.Dialer{Timeout: p.DialTimeout, KeepAlive: keepalive}
+}
+
+// outputDialer returns a function dialing the output of a pipe.
+func outputDialer(p pipe, priority syslog.Priority) (func() (io.WriteCloser, error), error) {
+	var tlsConf *tls.Config
+	if p.usesTLS() {
+		var err error
+		tlsConf, err = tlsConfig(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func() (io.WriteCloser, error) {
+		return dialOutput(p, priority, tlsConf)
+	}, nil
+}
+
+// destination describes the output of a pipe for log messages.
+func (p pipe) destination() string {
+	switch p.Output {
+	case outputGELF:
+		return "GELF at " + p.Address
+	case outputJSON:
+		return "JSON Lines at " + p.OutputPath
+	case outputRELP:
+		return "RELP at " + p.Address
+	case outputSplunk:
+		return "Splunk HEC at " + p.Address
+	case outputDatadog:
+		return "Datadog at " + p.datadogURL()
+	case outputLoki:
+		return "Loki at " + p.Address
+	case outputKafka:
+		return "Kafka topic " + p.KafkaTopic
+	case outputFluentd:
+		return "Fluentd at " + p.Address
+	case outputOTLP:
+		return "OTLP at " + p.Address
+	}
+
+	if p.Network == "" {
+		return "local syslog"
+	}
+
+	return "syslog at " + p.Network + "://" + p.Address
+}
+
+// lossy returns true if the output of the pipe doesn't guarantee delivery
+// anyway. Write errors are not fatal for lossy outputs.
+func (p pipe) lossy() bool {
+	return p.Network == "udp" || p.Output == outputGELF
+}
+
+// usesHTTP returns true if the pipe sends messages to an HTTP endpoint.
+func (p pipe) usesHTTP() bool {
+	return p.Output == outputSplunk || p.Output == outputLoki || p.Output == outputOTLP || p.Output == outputDatadog
+}
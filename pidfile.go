@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockedPidfile is a PID file we hold the lock of. The directory of the
+// file is kept open, so the file can still be removed from a chroot.
+type lockedPidfile struct {
+	fd  *os.File
+	dir *os.File
+}
+
+// lockPidfile creates the PID file at path, locks it and writes our PID to
+// it. The lock is held until the file is closed or the process exits, so a
+// PID file left behind by a crash doesn't block the next start.
+func lockPidfile(path string) (*lockedPidfile, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		content, _ := os.ReadFile(path)
+		fd.Close()
+
+		return nil, fmt.Errorf("%s is locked, logpipe is already running with PID %s", path, strings.TrimSpace(string(content)))
+	}
+	if err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("locking %s failed: %s", path, err.Error())
+	}
+
+	if err := fd.Truncate(0); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	if _, err := fd.WriteString(strconv.Itoa(os.Getpid()) + "\n"); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return &lockedPidfile{fd: fd, dir: dir}, nil
+}
+
+// removePidfile removes and unlocks the PID file. It's removed relative to
+// its directory, as the path may be outside the chroot by now. After
+// dropping privileges, we may not be allowed to remove it, but the next
+// start only needs the lock to be released.
+func removePidfile(p *lockedPidfile) {
+	err := unix.Unlinkat(int(p.dir.Fd()), filepath.Base(p.fd.Name()), 0)
+	if err != nil {
+		logWarning("Removing PID file %s failed: %s", p.fd.Name(), err.Error())
+	}
+
+	p.fd.Close()
+	p.dir.Close()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLockPidfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logpipe.pid")
+
+	pidfile, err := lockPidfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lockPidfile(path); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("locking a second time returned %v", err)
+	}
+
+	removePidfile(pidfile)
+
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("PID file not removed: %v", err)
+	}
+}
+
+// After entering a chroot, the path of the PID file doesn't lead to it
+// anymore, like when its directory was moved.
+func TestRemovePidfileMoved(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "run", "logpipe.pid")
+	if err := os.Mkdir(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pidfile, err := lockPidfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moved := filepath.Join(dir, "moved")
+	if err := os.Rename(filepath.Dir(path), moved); err != nil {
+		t.Fatal(err)
+	}
+
+	removePidfile(pidfile)
+
+	if _, err := os.Stat(filepath.Join(moved, "logpipe.pid")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("PID file not removed: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import "log/syslog"
+
+// Policies for a full queue
+const (
+	overflowBlock      = "block"
+	overflowDropOldest = "drop_oldest"
+	overflowDropNewest = "drop_newest"
+)
+
+// queuedLine is a message waiting to be written.
+type queuedLine struct {
+	message  string
+	priority syslog.Priority
+	tag      string
+	fields   map[string]string
+}
+
+// lineQueue decouples reading a pipe from writing to its output. When the
+// queue is full, the reader waits ("block", the default), or the oldest or
+// newest message is dropped and counted as an error.
+type lineQueue struct {
+	lines  chan queuedLine
+	policy string
+	stats  *pipeStats
+}
+
+// newLineQueue returns the queue of a pipe, or nil if queue_depth is not
+// set.
+func newLineQueue(p pipe, stats *pipeStats) *lineQueue {
+	if p.QueueDepth <= 0 {
+		return nil
+	}
+
+	policy := p.OverflowPolicy
+	if policy == "" {
+		policy = overflowBlock
+	}
+
+	return &lineQueue{
+		lines:  make(chan queuedLine, p.QueueDepth),
+		policy: policy,
+		stats:  stats,
+	}
+}
+
+// push queues a line according to the overflow policy.
+func (q *lineQueue) push(line queuedLine) {
+	switch q.policy {
+	case overflowDropNewest:
+		select {
+		case q.lines <- line:
+		default:
+			q.stats.errors.Add(1)
+			return
+		}
+
+	case overflowDropOldest:
+		for queued := false; !queued; {
+			select {
+			case q.lines <- line:
+				queued = true
+			default:
+				select {
+				case <-q.lines:
+					q.stats.queued.Add(-1)
+					q.stats.errors.Add(1)
+				default:
+				}
+			}
+		}
+
+	default:
+		q.lines <- line
+	}
+
+	q.stats.queued.Add(1)
+}
+
+// run writes queued lines until the queue is closed and empty.
+func (q *lineQueue) run(write func(message string, priority syslog.Priority, tag string, fields map[string]string)) {
+	for line := range q.lines {
+		q.stats.queued.Add(-1)
+		write(line.message, line.priority, line.tag, line.fields)
+	}
+}
+
+// close stops accepting lines. run returns when the remaining lines are
+// written.
+func (q *lineQueue) close() {
+	close(q.lines)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitReportInterval is how often the number of dropped messages is
+// reported.
+const rateLimitReportInterval = 60 * time.Second
+
+// rateLimiter limits the number of lines per second forwarded from a pipe.
+type rateLimiter struct {
+	limiter *rate.Limiter
+	delay   bool
+	dropped atomic.Int64
+}
+
+// newRateLimiter returns a rate limiter for the pipe, or nil if the pipe is
+// not rate limited.
+func newRateLimiter(p pipe) *rateLimiter {
+	if p.RateLimit <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{
+		limiter: rate.NewLimiter(rate.Limit(p.RateLimit), p.RateLimit),
+		delay:   p.RateLimitPolicy == "delay",
+	}
+}
+
+// allow returns true if a line can be forwarded. With the "delay" policy
+// it waits until the line is allowed, with the "drop" policy the line is
+// counted as dropped.
+func (r *rateLimiter) allow(ctx context.Context) bool {
+	if r.delay {
+		// If ctx is cancelled we're draining the pipe, and we let
+		// the line through
+		r.limiter.Wait(ctx)
+		return true
+	}
+
+	if r.limiter.Allow() {
+		return true
+	}
+
+	r.dropped.Add(1)
+
+	return false
+}
+
+// report writes the number of dropped messages to w periodically until
+// stop is closed.
+func (r *rateLimiter) report(stop <-chan struct{}, path string, w io.Writer) {
+	ticker := time.NewTicker(rateLimitReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		dropped := r.dropped.Swap(0)
+		if dropped == 0 {
+			continue
+		}
+
+		message := fmt.Sprintf("Rate limit exceeded for %s, dropped %d messages in the last %s\n", path, dropped, rateLimitReportInterval)
+		if _, err := w.Write([]byte(message)); err != nil {
+			logWarning("%s", strings.TrimSuffix(message, "\n"))
+		}
+	}
+}
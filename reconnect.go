@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 60 * time.Second
+
+	defaultReconnectBuffer = 1000
+)
+
+var (
+	errBufferFull        = errors.New("reconnect buffer is full")
+	errOutputUnavailable = errors.New("output is unavailable")
+)
+
+// dropFunc is called with each message an output drops.
+type dropFunc func(message string, fields map[string]string, cause error)
+
+// queuedMessage is a message waiting for delivery, with its fields if any.
+// Keep-alive messages are heartbeats queued while the connection is idle.
+type queuedMessage struct {
+	message   string
+	fields    map[string]string
+	keepalive bool
+}
+
+// keepaliveWriter is a writer that can send a heartbeat to keep an idle
+// connection alive.
+type keepaliveWriter interface {
+	keepalive() error
+}
+
+// ringBuffer is a fixed size queue of messages. When the buffer is full, the
+// oldest message is overwritten.
+type ringBuffer struct {
+	messages []queuedMessage
+	start    int
+	count    int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{messages: make([]queuedMessage, size)}
+}
+
+// push adds a message to the end of the buffer. If the oldest message was
+// dropped to make room, it's returned with true.
+func (r *ringBuffer) push(message queuedMessage) (queuedMessage, bool) {
+	end := (r.start + r.count) % len(r.messages)
+	oldest := r.messages[end]
+	r.messages[end] = message
+
+	if r.count == len(r.messages) {
+		r.start = (r.start + 1) % len(r.messages)
+		return oldest, true
+	}
+
+	r.count++
+	return queuedMessage{}, false
+}
+
+// peek returns the oldest message without removing it.
+func (r *ringBuffer) peek() queuedMessage {
+	return r.messages[r.start]
+}
+
+// pop removes the oldest message.
+func (r *ringBuffer) pop() {
+	r.messages[r.start] = queuedMessage{}
+	r.start = (r.start + 1) % len(r.messages)
+	r.count--
+}
+
+func (r *ringBuffer) len() int {
+	return r.count
+}
+
+// reconnectWriter writes to a remote output. If a write fails, the
+// connection is closed and re-dialed with exponential backoff. Messages
+// arriving while reconnecting are kept in a ring buffer. If writer is nil,
+// the output is dialed when the first message arrives. If keepalive is set,
+// a heartbeat is written when no message was written for that long, so a
+// connection dropped while idle is noticed and re-dialed. A circuit breaker
+// holds back attempts to an output that keeps failing. Messages dropped
+// because the buffer is full or the output is down when closing are passed
+// to drop.
+type reconnectWriter struct {
+	name      string
+	writer    io.WriteCloser
+	dial      func() (io.WriteCloser, error)
+	drop      dropFunc
+	keepalive time.Duration
+	breaker   *circuitBreaker
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	queue   *ringBuffer
+	closing bool
+	done    chan struct{}
+
+	// lastWrite is when the last message was queued
+	lastWrite time.Time
+
+	// ctx is cancelled when closing to interrupt backoff
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newReconnectWriter(p pipe, writer io.WriteCloser, dial func() (io.WriteCloser, error), drop dropFunc) *reconnectWriter {
+	bufferSize := p.ReconnectBuffer
+	if bufferSize <= 0 {
+		bufferSize = defaultReconnectBuffer
+	}
+
+	w := &reconnectWriter{
+		name:      p.destination(),
+		writer:    writer,
+		dial:      dial,
+		drop:      drop,
+		keepalive: p.KeepaliveInterval,
+		breaker:   newCircuitBreaker(p),
+		queue:     newRingBuffer(bufferSize),
+		done:      make(chan struct{}),
+		lastWrite: time.Now(),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	w.cond = sync.NewCond(&w.lock)
+
+	go w.run()
+	if w.keepalive > 0 {
+		go w.sendKeepalives()
+	}
+
+	return w
+}
+
+// Write queues a message for delivery. It never blocks on the network.
+func (w *reconnectWriter) Write(b []byte) (int, error) {
+	return w.WriteFields(b, nil)
+}
+
+// WriteFields queues a message with fields for delivery.
+func (w *reconnectWriter) WriteFields(b []byte, fields map[string]string) (int, error) {
+	w.lock.Lock()
+	oldest, dropped := w.queue.push(queuedMessage{message: string(b), fields: fields})
+	w.lastWrite = time.Now()
+	w.cond.Signal()
+	w.lock.Unlock()
+
+	if dropped && !oldest.keepalive {
+		logWarning("Buffer for %s is full, dropping oldest message", w.name)
+		w.drop(oldest.message, oldest.fields, errBufferFull)
+	}
+
+	return len(b), nil
+}
+
+// Close delivers the buffered messages and closes the connection. If the
+// connection is down, the buffered messages are dropped.
+func (w *reconnectWriter) Close() error {
+	w.lock.Lock()
+	w.closing = true
+	w.cond.Signal()
+	w.lock.Unlock()
+
+	w.cancel()
+
+	<-w.done
+
+	return nil
+}
+
+func (w *reconnectWriter) run() {
+	defer close(w.done)
+	defer w.breaker.close()
+
+	backoff := minBackoff
+	redialed := false
+
+	// lastErr is why the output is unavailable, for dropped messages
+	lastErr := errOutputUnavailable
+
+	for {
+		w.lock.Lock()
+		for w.queue.len() == 0 && !w.closing {
+			w.cond.Wait()
+		}
+		closing := w.closing
+		pending := w.queue.len()
+		if pending == 0 {
+			w.lock.Unlock()
+			if w.writer != nil {
+				w.writer.Close()
+			}
+			return
+		}
+		message := w.queue.peek()
+		w.lock.Unlock()
+
+		if w.writer == nil {
+			// When closing, we dial once more to deliver the buffered
+			// messages
+			if closing && redialed {
+				logError("Output %s is unavailable, dropping %d buffered messages", w.name, pending)
+				w.dropQueued(lastErr)
+				return
+			}
+			redialed = closing
+
+			if wait := w.breaker.wait(); wait > 0 {
+				if closing {
+					logError("Output %s is unavailable, dropping %d buffered messages", w.name, pending)
+					w.dropQueued(lastErr)
+					return
+				}
+				select {
+				case <-w.ctx.Done():
+				case <-time.After(wait):
+				}
+				continue
+			}
+
+			writer, err := w.dial()
+			if err != nil && closing {
+				logError("Output %s is unavailable, dropping %d buffered messages", w.name, pending)
+				w.dropQueued(err)
+				return
+			}
+			if err != nil {
+				lastErr = err
+				logWarning("Reconnecting to %s failed: %s", w.name, err.Error())
+				w.breaker.failure()
+				backoff = sleepBackoff(w.ctx, backoff)
+				continue
+			}
+
+			w.writer = writer
+		}
+
+		var err error
+		if message.keepalive {
+			if k, ok := w.writer.(keepaliveWriter); ok {
+				err = k.keepalive()
+			}
+		} else {
+			_, err = writeFields(w.writer, []byte(message.message), message.fields)
+		}
+		if err != nil {
+			logWarning("Writing to %s failed, reconnecting: %s", w.name, err.Error())
+			lastErr = err
+			w.writer.Close()
+			w.writer = nil
+			w.breaker.failure()
+			if !closing {
+				backoff = sleepBackoff(w.ctx, backoff)
+			}
+			continue
+		}
+
+		backoff = minBackoff
+		w.breaker.success()
+
+		w.lock.Lock()
+		w.queue.pop()
+		w.lock.Unlock()
+	}
+}
+
+// dropQueued empties the buffer, passing the messages to drop.
+func (w *reconnectWriter) dropQueued(cause error) {
+	w.lock.Lock()
+	var messages []queuedMessage
+	for w.queue.len() > 0 {
+		messages = append(messages, w.queue.peek())
+		w.queue.pop()
+	}
+	w.lock.Unlock()
+
+	for _, message := range messages {
+		if !message.keepalive {
+			w.drop(message.message, message.fields, cause)
+		}
+	}
+}
+
+// sendKeepalives queues a heartbeat whenever nothing was written for the
+// keepalive interval, until the writer is closed.
+func (w *reconnectWriter) sendKeepalives() {
+	timer := time.NewTimer(w.keepalive)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		w.lock.Lock()
+		idle := time.Since(w.lastWrite)
+		if idle >= w.keepalive {
+			if w.queue.len() == 0 {
+				w.queue.push(queuedMessage{keepalive: true})
+				w.cond.Signal()
+			}
+			w.lastWrite = time.Now()
+			idle = 0
+		}
+		w.lock.Unlock()
+
+		timer.Reset(w.keepalive - idle)
+	}
+}
+
+// sleepBackoff sleeps for backoff or until ctx is cancelled, and returns the
+// next backoff duration.
+func sleepBackoff(ctx context.Context, backoff time.Duration) time.Duration {
+	select {
+	case <-ctx.Done():
+	case <-time.After(backoff):
+	}
+
+	backoff *= 2
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	return backoff
+}
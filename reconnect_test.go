@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dropRecorder collects dropped messages.
+type dropRecorder struct {
+	lock     sync.Mutex
+	messages []string
+}
+
+func (r *dropRecorder) drop(message string, fields map[string]string, cause error) {
+	r.lock.Lock()
+	r.messages = append(r.messages, message)
+	r.lock.Unlock()
+}
+
+func (r *dropRecorder) get() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return slices.Clone(r.messages)
+}
+
+func TestRingBufferPush(t *testing.T) {
+	r := newRingBuffer(2)
+
+	for _, message := range []string{"one", "two"} {
+		if _, dropped := r.push(queuedMessage{message: message}); dropped {
+			t.Fatalf("%s dropped a message", message)
+		}
+	}
+
+	oldest, dropped := r.push(queuedMessage{message: "three"})
+	if !dropped || oldest.message != "one" {
+		t.Fatalf("dropped %q, %v, want \"one\"", oldest.message, dropped)
+	}
+
+	if r.len() != 2 || r.peek().message != "two" {
+		t.Errorf("buffer holds %d messages starting with %q", r.len(), r.peek().message)
+	}
+}
+
+func TestReconnectWriterDropsWhenUnavailable(t *testing.T) {
+	unavailable := errors.New("unavailable")
+	dial := func() (io.WriteCloser, error) {
+		return nil, unavailable
+	}
+
+	var r dropRecorder
+	w := newReconnectWriter(pipe{ReconnectBuffer: 2}, nil, dial, r.drop)
+
+	for _, message := range []string{"one", "two", "three"} {
+		w.Write([]byte(message))
+	}
+
+	// Close doesn't wait for the backoff of the first failed dial
+	closed := make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+
+	// The first message may be dropped from the full buffer, or after the
+	// writer picked it up for the first dial
+	dropped := r.get()
+	slices.Sort(dropped)
+	if want := []string{"one", "three", "two"}; !slices.Equal(dropped, want) {
+		t.Errorf("dropped %q, want %q", dropped, want)
+	}
+}
+
+func TestBatcherDropsWhenClosing(t *testing.T) {
+	send := func(batch []batchEntry) error {
+		return errors.New("unavailable")
+	}
+
+	var r dropRecorder
+	b := newBatcher("test", 10, time.Hour, send, r.drop)
+	b.Write([]byte("one"))
+	b.Write([]byte("two"))
+	b.Close()
+
+	if dropped := r.get(); !slices.Equal(dropped, []string{"one", "two"}) {
+		t.Errorf("dropped %q", dropped)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// relpTimeout is how long we wait for the server to acknowledge a
+	// command
+	relpTimeout = 10 * time.Second
+
+	// relpRetries is how many times an unacknowledged message is resent
+	// before giving up
+	relpRetries = 3
+
+	relpOffer = "relp_version=0\nrelp_software=logpipe\ncommands=syslog"
+)
+
+var errRELPClosed = errors.New("RELP connection closed")
+
+// relpResponse is the response to a RELP command.
+type relpResponse struct {
+	code int
+	data string
+}
+
+// relpConn is a RELP session on top of a network connection. Responses are
+// read by a separate goroutine and matched to commands by transaction
+// number.
+type relpConn struct {
+	conn net.Conn
+
+	lock    sync.Mutex
+	txnr    int
+	pending map[int]chan relpResponse
+	err     error
+}
+
+// dialRELP connects to a RELP server and opens a session. If timeout is 0,
+// relpTimeout is used.
+func dialRELP(address string, timeout time.Duration) (*relpConn, error) {
+	if timeout == 0 {
+		timeout = relpTimeout
+	}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &relpConn{
+		conn:    conn,
+		pending: make(map[int]chan relpResponse),
+	}
+
+	go c.readLoop()
+
+	rsp, err := c.call("open", relpOffer)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if rsp.code != 200 {
+		conn.Close()
+		return nil, fmt.Errorf("RELP server refused session: %d %s", rsp.code, rsp.data)
+	}
+
+	return c, nil
+}
+
+// call sends a command and waits for the server to acknowledge it.
+func (c *relpConn) call(command string, data string) (relpResponse, error) {
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return relpResponse{}, c.err
+	}
+
+	c.txnr++
+	txnr := c.txnr
+	rsp := make(chan relpResponse, 1)
+	c.pending[txnr] = rsp
+
+	frame := fmt.Sprintf("%d %s %d", txnr, command, len(data))
+	if len(data) > 0 {
+		frame += " " + data
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(relpTimeout))
+	_, err := io.WriteString(c.conn, frame+"\n")
+	c.lock.Unlock()
+
+	if err != nil {
+		c.fail(err)
+		return relpResponse{}, err
+	}
+
+	select {
+	case r, ok := <-rsp:
+		if !ok {
+			return relpResponse{}, c.failure()
+		}
+
+		return r, nil
+
+	case <-time.After(relpTimeout):
+		c.fail(errors.New("timeout waiting for RELP response"))
+		return relpResponse{}, c.failure()
+	}
+}
+
+// fail closes the connection and fails all pending commands.
+func (c *relpConn) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	c.conn.Close()
+
+	for txnr, rsp := range c.pending {
+		close(rsp)
+		delete(c.pending, txnr)
+	}
+}
+
+func (c *relpConn) failure() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.err
+}
+
+// readLoop reads responses from the server until the connection fails.
+func (c *relpConn) readLoop() {
+	reader := bufio.NewReader(c.conn)
+
+	for {
+		txnr, command, data, err := readRELPFrame(reader)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		if command == "serverclose" {
+			c.fail(errRELPClosed)
+			return
+		}
+
+		if command != "rsp" {
+			continue
+		}
+
+		code, text, _ := strings.Cut(data, " ")
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			c.fail(fmt.Errorf("invalid RELP response: %q", data))
+			return
+		}
+
+		c.lock.Lock()
+		rsp, found := c.pending[txnr]
+		delete(c.pending, txnr)
+		c.lock.Unlock()
+
+		if found {
+			rsp <- relpResponse{code: status, data: text}
+		}
+	}
+}
+
+// readRELPFrame reads a single frame: TXNR SP COMMAND SP DATALEN [SP DATA] LF
+func readRELPFrame(reader *bufio.Reader) (int, string, string, error) {
+	field, err := reader.ReadString(' ')
+	if err != nil {
+		return 0, "", "", err
+	}
+	txnr, err := strconv.Atoi(strings.TrimSuffix(field, " "))
+	if err != nil {
+		return 0, "", "", fmt.Errorf("invalid RELP transaction number: %q", field)
+	}
+
+	command, err := reader.ReadString(' ')
+	if err != nil {
+		return 0, "", "", err
+	}
+	command = strings.TrimSuffix(command, " ")
+
+	// DATALEN is followed by a space if there's data, otherwise by the
+	// trailer
+	var length []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, "", "", err
+		}
+
+		if b == ' ' || b == '\n' {
+			if b == '\n' {
+				reader.UnreadByte()
+			}
+			break
+		}
+
+		length = append(length, b)
+	}
+
+	datalen, err := strconv.Atoi(string(length))
+	if err != nil || datalen < 0 {
+		return 0, "", "", fmt.Errorf("invalid RELP data length: %q", length)
+	}
+
+	data := make([]byte, datalen)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return 0, "", "", err
+	}
+
+	trailer, err := reader.ReadByte()
+	if err != nil {
+		return 0, "", "", err
+	}
+	if trailer != '\n' {
+		return 0, "", "", fmt.Errorf("invalid RELP trailer: %q", trailer)
+	}
+
+	return txnr, command, string(data), nil
+}
+
+// Close ends the session and closes the connection.
+func (c *relpConn) Close() error {
+	if c.failure() == nil {
+		c.call("close", "")
+	}
+
+	c.fail(errRELPClosed)
+
+	return nil
+}
+
+// relpWriter sends syslog messages over RELP. Each message is acknowledged
+// by the server before Write returns.
+type relpWriter struct {
+	syslogFormatter
+	address string
+	timeout time.Duration
+	conn    *relpConn
+}
+
+func dialRELPWriter(p pipe, priority syslog.Priority) (*relpWriter, error) {
+	conn, err := dialRELP(p.Address, p.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &relpWriter{
+		syslogFormatter: newSyslogFormatter(p, priority),
+		address:         p.Address,
+		timeout:         p.DialTimeout,
+		conn:            conn,
+	}, nil
+}
+
+func (w *relpWriter) Write(b []byte) (int, error) {
+	return w.WriteFields(b, nil)
+}
+
+// WriteFields sends a message and waits for it to be acknowledged. If the
+// connection fails, we reconnect and resend the message up to relpRetries
+// times.
+func (w *relpWriter) WriteFields(b []byte, fields map[string]string) (int, error) {
+	message := w.formatMessage(string(b), fields)
+
+	var err error
+	for attempt := 0; attempt <= relpRetries; attempt++ {
+		if w.conn == nil {
+			w.conn, err = dialRELP(w.address, w.timeout)
+			if err != nil {
+				continue
+			}
+		}
+
+		var rsp relpResponse
+		rsp, err = w.conn.call("syslog", message)
+		if err == nil && rsp.code == 200 {
+			return len(b), nil
+		}
+
+		if err == nil {
+			err = fmt.Errorf("RELP server rejected message: %d %s", rsp.code, rsp.data)
+		}
+
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	return 0, err
+}
+
+func (w *relpWriter) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+
+	return w.conn.Close()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// trim removes the configured prefix, suffix and surrounding whitespace
+// from a line. It returns false if nothing is left of the line.
+func (p pipe) trim(line string) (string, bool) {
+	if p.TrimPrefix == "" && p.TrimSuffix == "" && !p.TrimSpace {
+		return line, true
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimPrefix(line, p.TrimPrefix)
+	line = strings.TrimSuffix(line, p.TrimSuffix)
+
+	if p.TrimSpace {
+		line = strings.TrimSpace(line)
+	}
+
+	if line == "" {
+		return "", false
+	}
+
+	return line + "\n", true
+}
+
+// defaultTagPrefixDelimiter separates the tag from the message when the tag
+// is taken from lines.
+const defaultTagPrefixDelimiter = ": "
+
+// splitTag returns the message and tag of a line. With
+// tag_from_line_prefix, the tag is taken from the start of the line up to
+// the delimiter. Otherwise, or if the line has no tag, the line is returned
+// as is with the configured tag.
+func (p pipe) splitTag(line string) (string, string) {
+	if !p.TagFromLinePrefix {
+		return line, p.Tag
+	}
+
+	delimiter := p.TagPrefixDelimiter
+	if delimiter == "" {
+		delimiter = defaultTagPrefixDelimiter
+	}
+
+	fallback := p.TagPrefixFallback
+	if fallback == "" {
+		fallback = p.Tag
+	}
+
+	tag, message, found := strings.Cut(line, delimiter)
+	if !found || tag == "" || strings.Contains(tag, "\n") {
+		return line, fallback
+	}
+
+	return message, tag
+}
+
+// timestampField is the field holding the time of messages parsed as JSON
+// with prepend_timestamp.
+const timestampField = "timestamp"
+
+// stamp prepends the time to a message if prepend_timestamp is set. Fields
+// are only set for messages parsed as JSON, so those get the time as a
+// field instead.
+func (p pipe) stamp(message string, fields map[string]string, now time.Time) (string, map[string]string) {
+	if !p.PrependTimestamp {
+		return message, fields
+	}
+
+	format := p.TimestampFormat
+	if format == "" {
+		format = time.RFC3339Nano
+	}
+	timestamp := now.UTC().Format(format)
+
+	if fields != nil {
+		fields = maps.Clone(fields)
+		fields[timestampField] = timestamp
+		return message, fields
+	}
+
+	return timestamp + " " + message, fields
+}
+
+// rewrite is a single regular expression substitution.
+type rewrite struct {
+	Regex string `toml:"regex"`
+	With  string `toml:"with"`
+}
+
+type compiledRewrite struct {
+	regex *regexp.Regexp
+	with  string
+}
+
+// rewriter applies substitutions to lines before they are forwarded.
+type rewriter []compiledRewrite
+
+// newRewriter compiles the substitutions configured for a pipe. The
+// rewrite_regex field is applied first, then each [[pipe.rewrite]] in order.
+func newRewriter(p pipe) (rewriter, error) {
+	rewrites := p.Rewrite
+	if p.RewriteRegex != "" {
+		rewrites = append([]rewrite{{Regex: p.RewriteRegex, With: p.RewriteWith}}, rewrites...)
+	}
+
+	var r rewriter
+	for _, rw := range rewrites {
+		if rw.Regex == "" {
+			return nil, fmt.Errorf("%s has a rewrite without a regex", p.source())
+		}
+
+		regex, err := regexp.Compile(rw.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("%s has invalid rewrite regex: %s", p.source(), err.Error())
+		}
+
+		r = append(r, compiledRewrite{regex: regex, with: rw.With})
+	}
+
+	return r, nil
+}
+
+// rewrite applies all substitutions to the line. It returns false if the
+// line is empty after rewriting and should be dropped.
+func (r rewriter) rewrite(line string) (string, bool) {
+	if len(r) == 0 {
+		return line, true
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	for _, rw := range r {
+		line = rw.regex.ReplaceAllString(line, rw.with)
+	}
+
+	if line == "" {
+		return "", false
+	}
+
+	return line + "\n", true
+}
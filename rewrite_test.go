@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRewriter(t *testing.T) {
+	tests := []struct {
+		name string
+		pipe pipe
+		line string
+		want string
+		ok   bool
+	}{
+		{
+			name: "none",
+			line: "password=secret\n",
+			want: "password=secret\n",
+			ok:   true,
+		},
+		{
+			name: "rewrite_regex",
+			pipe: pipe{RewriteRegex: `password=\S+`, RewriteWith: "password=***"},
+			line: "login password=secret user=bob\n",
+			want: "login password=*** user=bob\n",
+			ok:   true,
+		},
+		{
+			name: "capture groups",
+			pipe: pipe{RewriteRegex: `(\d+)\.(\d+)\.\d+\.\d+`, RewriteWith: "$1.$2.x.x"},
+			line: "client 192.168.1.10\n",
+			want: "client 192.168.x.x\n",
+			ok:   true,
+		},
+		{
+			name: "rewrite_regex before [[pipe.rewrite]]",
+			pipe: pipe{
+				RewriteRegex: "a",
+				RewriteWith:  "b",
+				Rewrite:      []rewrite{{Regex: "b", With: "c"}, {Regex: "c", With: "d"}},
+			},
+			line: "abc\n",
+			want: "ddd\n",
+			ok:   true,
+		},
+		{
+			name: "emptied line is dropped",
+			pipe: pipe{RewriteRegex: ".*"},
+			line: "debug noise\n",
+			ok:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := newRewriter(test.pipe)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			line, ok := r.rewrite(test.line)
+			if line != test.want || ok != test.ok {
+				t.Errorf("rewrite(%q) = %q, %v, want %q, %v", test.line, line, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestRewriterInvalid(t *testing.T) {
+	for _, p := range []pipe{
+		{RewriteRegex: "("},
+		{Rewrite: []rewrite{{With: "x"}}},
+	} {
+		if _, err := newRewriter(p); err == nil {
+			t.Errorf("%+v accepted", p.Rewrite)
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	tests := []struct {
+		pipe pipe
+		line string
+		want string
+		ok   bool
+	}{
+		{pipe{}, "  as is  \n", "  as is  \n", true},
+		{pipe{TrimPrefix: "app: "}, "app: started\n", "started\n", true},
+		{pipe{TrimSuffix: ";"}, "started;\n", "started\n", true},
+		{pipe{TrimSpace: true}, "  started \t\n", "started\n", true},
+		{pipe{TrimPrefix: "app:", TrimSpace: true}, "app:   \n", "", false},
+	}
+
+	for _, test := range tests {
+		line, ok := test.pipe.trim(test.line)
+		if line != test.want || ok != test.ok {
+			t.Errorf("trim(%q) = %q, %v, want %q, %v", test.line, line, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestSplitTag(t *testing.T) {
+	tests := []struct {
+		pipe    pipe
+		line    string
+		message string
+		tag     string
+	}{
+		{pipe{Tag: "app"}, "web: started\n", "web: started\n", "app"},
+		{pipe{Tag: "app", TagFromLinePrefix: true}, "web: started\n", "started\n", "web"},
+		{pipe{Tag: "app", TagFromLinePrefix: true}, "started\n", "started\n", "app"},
+		{pipe{Tag: "app", TagFromLinePrefix: true, TagPrefixFallback: "unknown"}, ": started\n", ": started\n", "unknown"},
+		{pipe{Tag: "app", TagFromLinePrefix: true, TagPrefixDelimiter: "|"}, "web|started\n", "started\n", "web"},
+	}
+
+	for _, test := range tests {
+		message, tag := test.pipe.splitTag(test.line)
+		if message != test.message || tag != test.tag {
+			t.Errorf("splitTag(%q) = %q, %q, want %q, %q", test.line, message, tag, test.message, test.tag)
+		}
+	}
+}
+
+func TestStamp(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	p := pipe{PrependTimestamp: true, TimestampFormat: time.RFC3339}
+	if message, _ := p.stamp("started\n", nil, now); message != "2024-05-01T12:30:00Z started\n" {
+		t.Errorf("stamped %q", message)
+	}
+
+	fields := map[string]string{"level": "info"}
+	message, stamped := p.stamp("started\n", fields, now)
+	if message != "started\n" || stamped[timestampField] != "2024-05-01T12:30:00Z" {
+		t.Errorf("stamped %q with fields %v", message, stamped)
+	}
+	if _, found := fields[timestampField]; found {
+		t.Error("fields of the message were modified")
+	}
+}
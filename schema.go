@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+)
+
+// durationPattern matches durations as parsed by time.ParseDuration.
+const durationPattern = `^-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+var outputs = []string{outputSyslog, outputGELF, outputJSON, outputRELP, outputSplunk, outputLoki, outputKafka, outputFluentd, outputOTLP, outputDatadog}
+
+// schemaEnums lists the values of settings with a fixed set of values, by
+// section and key, or by key for all sections.
+var schemaEnums = map[string][]string{
+	"facility":               nil, // filled from facilities
+	"severity":               nil, // filled from severities
+	"pipe.output":            outputs,
+	"pipe.network":           {"tcp", "udp"},
+	"syslog.network":         {"tcp", "udp"},
+	"pipe.format":            {formatRFC3164, formatRFC5424},
+	"pipe.tcp_framing":       {framingNewline, framingOctetCount},
+	"pipe.output_format":     {eventFormatCEF, eventFormatLEEF},
+	"pipe.leef_version":      {leefVersion1, leefVersion2},
+	"pipe.otlp_protocol":     {otlpHTTP},
+	"pipe.rate_limit_policy": {"drop", "delay"},
+	"pipe.oversized_policy":  {oversizedTruncate, oversizedSplit},
+	"pipe.overflow_policy":   {overflowBlock, overflowDropOldest, overflowDropNewest},
+	"pipe.ttl_action":        {ttlRemove, ttlStop},
+	"logging.output":         {"stderr", "syslog", "file"},
+	"reader_mode":            {readerGoroutine, readerEpoll},
+}
+
+// schemaDescriptions describes the most common settings, by section and
+// key, or by key for all sections.
+var schemaDescriptions = map[string]string{
+	"pipe":             "A named pipe, or another input, and where to forward its lines",
+	"pipe.path":        "Path of the named pipe, \"-\" for stdin",
+	"pipe.tag":         "Syslog tag of the messages",
+	"pipe.output":      "Where to send messages, or a list of outputs",
+	"pipe.address":     "Address of the output",
+	"pipe.network":     "Network of remote syslog",
+	"facility":         "Syslog facility",
+	"severity":         "Syslog severity",
+	"include":          "Glob patterns of files with more pipes",
+	"syslog":           "Defaults for all pipes",
+	"metrics":          "Prometheus metrics on /metrics",
+	"health":           "Health checks on /healthz",
+	"security":         "User, group and chroot to run in",
+	"logging":          "Where logpipe's own messages go",
+	"shutdown_timeout": "How long to wait for pipes to drain on shutdown",
+}
+
+// schemaDefaults are the defaults of settings shown in the schema.
+var schemaDefaults = map[string]any{
+	"pipe.output":            outputSyslog,
+	"pipe.mode":              fmt.Sprintf("%04o", defaultFifoMode),
+	"pipe.max_mode":          fmt.Sprintf("%04o", defaultMaxMode),
+	"pipe.socket_mode":       fmt.Sprintf("%04o", defaultSocketMode),
+	"pipe.format":            formatRFC3164,
+	"pipe.tcp_framing":       framingNewline,
+	"pipe.line_delimiter":    delimiterLF,
+	"pipe.overflow_policy":   overflowBlock,
+	"pipe.ttl_action":        ttlRemove,
+	"pipe.oversized_policy":  oversizedTruncate,
+	"pipe.batch_size":        defaultBatchSize,
+	"pipe.batch_timeout":     defaultBatchTimeout.String(),
+	"pipe.datadog_site":      defaultDatadogSite,
+	"pipe.open_duration":     defaultOpenDuration.String(),
+	"shutdown_timeout":       defaultShutdownTimeout.String(),
+	"health.health_timeout":  defaultHealthTimeout.String(),
+	"security.syslog_socket": defaultSyslogSocket,
+	"reader_mode":            readerGoroutine,
+}
+
+// printSchema prints a JSON Schema of the configuration file, generated
+// from the configuration structs. It returns the exit code.
+func printSchema() int {
+	schema := structSchema(reflect.TypeFor[config](), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "logpipe configuration"
+
+	out, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Encoding schema failed: %s\n", err.Error())
+		return exitRuntimeError
+	}
+
+	fmt.Println(string(out))
+
+	return exitOK
+}
+
+// structSchema returns the schema of a configuration struct. Unknown keys
+// are not allowed, like logpipe -validate reports them.
+func structSchema(t reflect.Type, section string) map[string]any {
+	properties := make(map[string]any)
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		key := field.Tag.Get("toml")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		path := key
+		if section != "" {
+			path = section + "." + key
+		}
+
+		properties[key] = fieldSchema(field.Type, path, key)
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// fieldSchema returns the schema of a setting.
+func fieldSchema(t reflect.Type, path string, key string) map[string]any {
+	var schema map[string]any
+
+	switch {
+	case t == primitiveType && path == "pipe.output":
+		schema = map[string]any{
+			"oneOf": []any{
+				map[string]any{"type": "string"},
+				map[string]any{"type": "array", "items": outputTableSchema()},
+			},
+		}
+
+	case t == durationType:
+		schema = map[string]any{"type": "string", "pattern": durationPattern}
+
+	case t.Kind() == reflect.Pointer:
+		return fieldSchema(t.Elem(), path, key)
+
+	case t.Kind() == reflect.Struct:
+		schema = structSchema(t, path)
+
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct:
+		schema = map[string]any{"type": "array", "items": structSchema(t.Elem(), path)}
+
+	case t.Kind() == reflect.Slice:
+		schema = map[string]any{"type": "array", "items": fieldSchema(t.Elem(), path, key)}
+
+	case t.Kind() == reflect.Map:
+		schema = map[string]any{"type": "object", "additionalProperties": fieldSchema(t.Elem(), path, key)}
+
+	case t.Kind() == reflect.String:
+		schema = map[string]any{"type": "string"}
+
+	case t.Kind() == reflect.Bool:
+		schema = map[string]any{"type": "boolean"}
+
+	case t.Kind() == reflect.Int || t.Kind() == reflect.Int64:
+		schema = map[string]any{"type": "integer"}
+
+	default:
+		schema = map[string]any{}
+	}
+
+	if values := schemaEnum(path, key); values != nil {
+		if path == "pipe.output" {
+			schema["oneOf"].([]any)[0].(map[string]any)["enum"] = values
+		} else {
+			schema["enum"] = values
+		}
+	}
+
+	if description, found := lookup(schemaDescriptions, path, key); found {
+		schema["description"] = description
+	}
+
+	if def, found := lookup(schemaDefaults, path, key); found {
+		schema["default"] = def
+	}
+
+	return schema
+}
+
+// outputTableSchema returns the schema of a [[pipe.output]] table, which
+// takes the output settings of a pipe and a type.
+func outputTableSchema() map[string]any {
+	table := structSchema(reflect.TypeFor[pipe](), "output")
+	properties := table["properties"].(map[string]any)
+
+	for key := range properties {
+		if !outputKeys[key] {
+			delete(properties, key)
+		}
+	}
+
+	properties["type"] = map[string]any{"type": "string", "enum": outputs}
+	table["required"] = []string{"type"}
+
+	return table
+}
+
+// schemaEnum returns the values of a setting with a fixed set of values.
+func schemaEnum(path string, key string) []string {
+	switch key {
+	case "facility":
+		return sortedKeys(facilities)
+	case "severity":
+		return sortedKeys(severities)
+	}
+
+	values, _ := lookup(schemaEnums, path, key)
+	return values
+}
+
+func lookup[V any](m map[string]V, path string, key string) (V, bool) {
+	if value, found := m[path]; found {
+		return value, true
+	}
+
+	value, found := m[key]
+	return value, found
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// defaultSyslogSocket is the local syslog socket inside a chroot.
+const defaultSyslogSocket = "/dev/log"
+
+// securityConfig configures the user and group logpipe runs as after
+// creating the named pipes, and the directory it's jailed in.
+type securityConfig struct {
+	User  string `toml:"user"`
+	Group string `toml:"group"`
+
+	// Chroot is entered after the named pipes are opened. Other paths are
+	// relative to it from then on
+	Chroot string `toml:"chroot"`
+
+	// SyslogSocket is the local syslog socket, relative to the chroot
+	SyslogSocket string `toml:"syslog_socket"`
+}
+
+// ids looks up the uid and gid to run as. If no group is set, the primary
+// group of the user is used.
+func (s securityConfig) ids() (int, int, error) {
+	u, err := user.Lookup(s.User)
+	if err != nil {
+		return 0, 0, fmt.Errorf("looking up user %s failed: %w", s.User, err)
+	}
+
+	gidString := u.Gid
+	if s.Group != "" {
+		g, err := user.LookupGroup(s.Group)
+		if err != nil {
+			return 0, 0, fmt.Errorf("looking up group %s failed: %w", s.Group, err)
+		}
+		gidString = g.Gid
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("user %s has invalid uid (%s)", s.User, u.Uid)
+	}
+
+	gid, err := strconv.Atoi(gidString)
+	if err != nil {
+		return 0, 0, fmt.Errorf("group has invalid gid (%s)", gidString)
+	}
+
+	return uid, gid, nil
+}
+
+// checkSecurity validates the [security] section.
+func checkSecurity(s securityConfig) []error {
+	var errs []error
+
+	if s.User == "" && s.Group != "" {
+		errs = append(errs, fmt.Errorf("[security] sets group %s without a user", s.Group))
+	}
+
+	if s.User != "" {
+		if _, _, err := s.ids(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if s.Chroot != "" && !filepath.IsAbs(s.Chroot) {
+		errs = append(errs, fmt.Errorf("chroot must be an absolute path (%s)", s.Chroot))
+	}
+
+	if s.SyslogSocket != "" && !filepath.IsAbs(s.SyslogSocket) {
+		errs = append(errs, fmt.Errorf("syslog_socket must be an absolute path (%s)", s.SyslogSocket))
+	}
+
+	return errs
+}
+
+// createFifos creates the named pipes of all pipes, so they exist before
+// privileges are dropped.
+func createFifos(pipes []pipe) error {
+	for _, p := range pipes {
+		if !p.isFifo() || preopenedFifo(p.Path) != nil {
+			continue
+		}
+
+		mode, _ := p.fifoMode()
+		uid, gid := p.owner()
+
+		if err := createFifo(p.Path, mode, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPermissions warns about named pipes more permissive than their
+// max_mode, since anyone allowed to write to them can inject messages. With
+// strict_permissions, an error is returned instead.
+func checkPermissions(config config) error {
+	for _, p := range config.Pipe {
+		if !p.isFifo() {
+			continue
+		}
+
+		var fileInfo os.FileInfo
+		var err error
+		if fd := preopenedFifo(p.Path); fd != nil {
+			fileInfo, err = fd.Stat()
+		} else {
+			fileInfo, err = os.Stat(p.Path)
+		}
+		if err != nil {
+			return err
+		}
+
+		maxMode, _ := parseMode(p.MaxMode, defaultMaxMode)
+		mode := fileInfo.Mode().Perm()
+		if mode&^maxMode == 0 {
+			continue
+		}
+
+		if config.StrictPermissions {
+			return fmt.Errorf("%s has mode %04o, more permissive than %04o", p.Path, mode, maxMode)
+		}
+		logWarning("%s has mode %04o, more permissive than %04o", p.Path, mode, maxMode)
+	}
+
+	return nil
+}
+
+// enterChroot jails logpipe in the configured directory. The named pipes
+// must have been opened by preopenFifos, since they can't be reached
+// afterwards.
+func enterChroot(s securityConfig) error {
+	if s.Chroot == "" {
+		return nil
+	}
+
+	if err := syscall.Chroot(s.Chroot); err != nil {
+		return fmt.Errorf("chroot to %s failed: %w", s.Chroot, err)
+	}
+
+	if err := os.Chdir("/"); err != nil {
+		return fmt.Errorf("changing directory to / in %s failed: %w", s.Chroot, err)
+	}
+
+	syslogSocket = s.SyslogSocket
+	if syslogSocket == "" {
+		syslogSocket = defaultSyslogSocket
+	}
+
+	return nil
+}
+
+// dropPrivileges switches to the configured user and group. The
+// supplementary groups are replaced first, since they can't be changed
+// after giving up root. If logpipe isn't running as root, nothing is done.
+func dropPrivileges(s securityConfig) error {
+	if s.User == "" {
+		return nil
+	}
+
+	if os.Geteuid() != 0 {
+		logWarning("Not running as root, keeping user and group")
+		return nil
+	}
+
+	uid, gid, err := s.ids()
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setting supplementary groups failed: %w", err)
+	}
+
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setting group to %d failed: %w", gid, err)
+	}
+
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setting user to %d failed: %w", uid, err)
+	}
+
+	return nil
+}
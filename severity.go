@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"regexp"
+	"slices"
+)
+
+// severityRule sets the severity of lines matching a regular expression.
+type severityRule struct {
+	Regex    string `toml:"regex"`
+	Severity string `toml:"severity"`
+}
+
+type compiledSeverityRule struct {
+	regex    *regexp.Regexp
+	severity syslog.Priority
+}
+
+// severityMap picks the severity of a line from the first matching rule.
+type severityMap []compiledSeverityRule
+
+// newSeverityMap compiles the [[pipe.severity_map]] rules of a pipe.
+func newSeverityMap(p pipe) (severityMap, error) {
+	var m severityMap
+
+	for _, rule := range p.SeverityMap {
+		if rule.Regex == "" {
+			return nil, fmt.Errorf("%s has a severity_map entry without a regex", p.source())
+		}
+
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("%s has invalid severity_map regex: %s", p.source(), err.Error())
+		}
+
+		severity, found := severities[rule.Severity]
+		if !found {
+			return nil, fmt.Errorf("%s has unknown severity in severity_map (%s)", p.source(), rule.Severity)
+		}
+
+		m = append(m, compiledSeverityRule{regex: regex, severity: severity})
+	}
+
+	return m, nil
+}
+
+// severity returns the severity for line, or def if no rule matches.
+func (m severityMap) severity(line string, def syslog.Priority) syslog.Priority {
+	for _, rule := range m {
+		if rule.regex.MatchString(line) {
+			return rule.severity
+		}
+	}
+
+	return def
+}
+
+// maxTagWriters is the number of outputs opened for tags other than the
+// tag of the pipe. Tags taken from lines are up to the writer, so they
+// can't each get a connection.
+const maxTagWriters = 64
+
+// writerKey identifies an output of a pipe in priorityWriters.
+type writerKey struct {
+	priority syslog.Priority
+	tag      string
+}
+
+// priorityWriters holds an output per priority and tag, as both are fixed
+// when dialing. Outputs are opened on first use. Once maxTagWriters outputs
+// were opened for other tags, messages with new tags are sent with the tag
+// of the pipe.
+type priorityWriters struct {
+	pipe       pipe
+	writers    map[writerKey]io.WriteCloser
+	tagWriters int
+	warned     bool
+}
+
+func newPriorityWriters(p pipe) *priorityWriters {
+	return &priorityWriters{
+		pipe:    p,
+		writers: make(map[writerKey]io.WriteCloser),
+	}
+}
+
+// get returns the output for priority and tag, opening it if needed.
+func (w *priorityWriters) get(priority syslog.Priority, tag string) (io.WriteCloser, error) {
+	key := writerKey{priority: priority, tag: tag}
+
+	writer, found := w.writers[key]
+	if found {
+		return writer, nil
+	}
+
+	if tag != w.pipe.Tag && w.tagWriters >= maxTagWriters {
+		if !w.warned {
+			logWarning("%s has more than %d tags, sending messages with new tags as %s", w.pipe.source(), maxTagWriters, w.pipe.Tag)
+			w.warned = true
+		}
+
+		return w.get(priority, w.pipe.Tag)
+	}
+
+	p := w.pipe
+	if tag != p.Tag {
+		p.Tag = tag
+		p.Outputs = slices.Clone(p.Outputs)
+		for i := range p.Outputs {
+			p.Outputs[i].Tag = tag
+		}
+	}
+
+	writer, err := openOutput(p, priority)
+	if err != nil {
+		return nil, err
+	}
+
+	w.writers[key] = writer
+	if tag != w.pipe.Tag {
+		w.tagWriters++
+	}
+
+	return writer, nil
+}
+
+func (w *priorityWriters) Close() error {
+	var errs []error
+
+	for _, writer := range w.writers {
+		errs = append(errs, writer.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"path/filepath"
+	"testing"
+)
+
+func TestPriorityWritersTagLimit(t *testing.T) {
+	p := pipe{
+		Path:       "/tmp/mux_log",
+		Tag:        "mux",
+		Output:     outputJSON,
+		OutputPath: filepath.Join(t.TempDir(), "mux.jsonl"),
+	}
+
+	writers := newPriorityWriters(p)
+	defer writers.Close()
+
+	priority := syslog.LOG_LOCAL6 | syslog.LOG_INFO
+	for i := range maxTagWriters {
+		if _, err := writers.get(priority, fmt.Sprintf("tag%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fallback, err := writers.get(priority, "one too many")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tag of the pipe gets a writer for each priority regardless
+	writer, err := writers.get(priority, p.Tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fallback != writer {
+		t.Error("new tag past the limit didn't get the writer of the pipe's tag")
+	}
+
+	if _, err := writers.get(syslog.LOG_LOCAL6|syslog.LOG_ERR, p.Tag); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(writers.writers) != maxTagWriters+2 {
+		t.Errorf("%d writers open, want %d", len(writers.writers), maxTagWriters+2)
+	}
+}
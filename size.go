@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultMaxMessageSize = 1024
+	defaultSplitSuffix    = " [%d/%d]"
+
+	oversizedTruncate = "truncate"
+	oversizedSplit    = "split"
+
+	truncatedSuffix = "[TRUNCATED]"
+)
+
+// sizeLimit truncates or splits messages longer than max bytes.
+type sizeLimit struct {
+	max    int
+	split  bool
+	suffix string
+}
+
+// newSizeLimit returns the message size limit of a pipe, or nil if neither
+// max_message_size nor oversized_policy is set.
+func newSizeLimit(p pipe) (*sizeLimit, error) {
+	if p.MaxMessageSize == 0 && p.OversizedPolicy == "" {
+		return nil, nil
+	}
+
+	l := &sizeLimit{
+		max:    p.MaxMessageSize,
+		suffix: p.SplitSuffix,
+	}
+
+	if l.max == 0 {
+		l.max = defaultMaxMessageSize
+	}
+
+	if l.suffix == "" {
+		l.suffix = defaultSplitSuffix
+	}
+
+	switch p.OversizedPolicy {
+	case oversizedTruncate, "":
+	case oversizedSplit:
+		l.split = true
+	default:
+		return nil, fmt.Errorf("%s has unknown oversized_policy (%s)", p.source(), p.OversizedPolicy)
+	}
+
+	if strings.Count(l.suffix, "%") != 2 || strings.Count(l.suffix, "%d") != 2 {
+		return nil, fmt.Errorf("%s must have split_suffix with two %%d for the part number and count", p.source())
+	}
+
+	// Leave room for some of the message after the suffix
+	if l.max < len(truncatedSuffix)+8 || l.max < len(fmt.Sprintf(l.suffix, 999, 999))+8 {
+		return nil, fmt.Errorf("%s has too small max_message_size (%d)", p.source(), l.max)
+	}
+
+	return l, nil
+}
+
+// apply returns the message as one or more messages of at most max bytes,
+// not counting the newline.
+func (l *sizeLimit) apply(message string) []string {
+	if l == nil || len(strings.TrimSuffix(message, "\n")) <= l.max {
+		return []string{message}
+	}
+
+	message = strings.TrimSuffix(message, "\n")
+
+	if !l.split {
+		return []string{cutUTF8(message, l.max-len(truncatedSuffix)) + truncatedSuffix + "\n"}
+	}
+
+	// The suffix grows with the number of parts, so we count the parts
+	// until the suffix is large enough
+	count := 1
+	for {
+		size := l.max - len(fmt.Sprintf(l.suffix, count, count))
+		needed := (len(message) + size - 1) / size
+		if needed <= count {
+			break
+		}
+		count = needed
+	}
+
+	size := l.max - len(fmt.Sprintf(l.suffix, count, count))
+
+	var parts []string
+	for len(message) > 0 {
+		part := cutUTF8(message, size)
+		message = message[len(part):]
+		parts = append(parts, part)
+	}
+
+	for i := range parts {
+		parts[i] += fmt.Sprintf(l.suffix, i+1, len(parts)) + "\n"
+	}
+
+	return parts
+}
+
+// cutUTF8 returns at most n bytes of s without splitting a character.
+func cutUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
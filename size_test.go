@@ -0,0 +1,113 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSizeLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		pipe    pipe
+		message string
+		want    []string
+	}{
+		{
+			name:    "no limit",
+			message: strings.Repeat("x", 2000) + "\n",
+			want:    []string{strings.Repeat("x", 2000) + "\n"},
+		},
+		{
+			name:    "short",
+			pipe:    pipe{MaxMessageSize: 32},
+			message: "short enough\n",
+			want:    []string{"short enough\n"},
+		},
+		{
+			name:    "exactly max",
+			pipe:    pipe{MaxMessageSize: 32},
+			message: strings.Repeat("x", 32) + "\n",
+			want:    []string{strings.Repeat("x", 32) + "\n"},
+		},
+		{
+			name:    "truncate",
+			pipe:    pipe{MaxMessageSize: 32},
+			message: strings.Repeat("x", 40) + "\n",
+			want:    []string{strings.Repeat("x", 21) + "[TRUNCATED]\n"},
+		},
+		{
+			name:    "truncate without splitting a character",
+			pipe:    pipe{MaxMessageSize: 32},
+			message: strings.Repeat("x", 20) + "äöü" + strings.Repeat("x", 20) + "\n",
+			want:    []string{strings.Repeat("x", 20) + "[TRUNCATED]\n"},
+		},
+		{
+			name:    "split",
+			pipe:    pipe{MaxMessageSize: 32, OversizedPolicy: oversizedSplit},
+			message: strings.Repeat("a", 26) + strings.Repeat("b", 26) + "c\n",
+			want: []string{
+				strings.Repeat("a", 26) + " [1/3]\n",
+				strings.Repeat("b", 26) + " [2/3]\n",
+				"c [3/3]\n",
+			},
+		},
+		{
+			name:    "split suffix",
+			pipe:    pipe{MaxMessageSize: 32, OversizedPolicy: oversizedSplit, SplitSuffix: " (%d of %d)"},
+			message: strings.Repeat("a", 23) + strings.Repeat("b", 10) + "\n",
+			want: []string{
+				strings.Repeat("a", 23) + " (1 of 2)\n",
+				strings.Repeat("b", 10) + " (2 of 2)\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := newSizeLimit(test.pipe)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if parts := l.apply(test.message); !slices.Equal(parts, test.want) {
+				t.Errorf("parts %q, want %q", parts, test.want)
+			}
+		})
+	}
+}
+
+// A 3000 byte line split with the default suffix becomes three messages.
+func TestSizeLimitSplitThree(t *testing.T) {
+	l, err := newSizeLimit(pipe{MaxMessageSize: 1024, OversizedPolicy: oversizedSplit})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := l.apply(strings.Repeat("x", 3000) + "\n")
+	if len(parts) != 3 {
+		t.Fatalf("%d parts, want 3", len(parts))
+	}
+
+	for i, part := range parts {
+		if len(strings.TrimSuffix(part, "\n")) > 1024 {
+			t.Errorf("part %d has %d bytes", i+1, len(part))
+		}
+	}
+	if !strings.HasSuffix(parts[2], " [3/3]\n") {
+		t.Errorf("last part ends with %q", parts[2][len(parts[2])-10:])
+	}
+}
+
+func TestSizeLimitInvalid(t *testing.T) {
+	for _, p := range []pipe{
+		{OversizedPolicy: "drop"},
+		{MaxMessageSize: 10},
+		{OversizedPolicy: oversizedSplit, SplitSuffix: " [%d]"},
+		{OversizedPolicy: oversizedSplit, SplitSuffix: " [%s/%d]"},
+	} {
+		if _, err := newSizeLimit(p); err == nil {
+			t.Errorf("%+v accepted", p)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"log/syslog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// splunkEvent is a single event sent to the Splunk HTTP Event Collector.
+type splunkEvent struct {
+	Time       float64           `json:"time"`
+	Host       string            `json:"host"`
+	Source     string            `json:"source"`
+	Sourcetype string            `json:"sourcetype,omitempty"`
+	Event      string            `json:"event"`
+	Fields     map[string]string `json:"fields"`
+}
+
+// splunkWriter sends messages in batches to the Splunk HTTP Event Collector.
+type splunkWriter struct {
+	*batcher
+	client   *http.Client
+	url      string
+	token    string
+	host     string
+	source   string
+	tag      string
+	facility string
+	severity string
+}
+
+func newSplunkWriter(p pipe, priority syslog.Priority, tlsConfig *tls.Config) *splunkWriter {
+	w := &splunkWriter{
+		client:   newHTTPClient(tlsConfig),
+		url:      strings.TrimSuffix(p.Address, "/") + "/services/collector/event",
+		token:    p.SplunkToken,
+		host:     p.hostname(),
+		source:   p.source(),
+		tag:      p.Tag,
+		facility: facilityName(priority),
+		severity: severityName(priority),
+	}
+	w.batcher = newBatcher("Splunk HEC", p.BatchSize, p.BatchTimeout, w.send, deadLetterDrop(p, priority))
+
+	return w
+}
+
+// send posts a batch of events as newline-delimited JSON.
+func (w *splunkWriter) send(batch []batchEntry) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+
+	for _, entry := range batch {
+		err := encoder.Encode(splunkEvent{
+			Time:       float64(entry.time.UnixNano()) / float64(time.Second),
+			Host:       w.host,
+			Source:     w.source,
+			Sourcetype: w.tag,
+			Event:      strings.TrimSuffix(entry.message, "\n"),
+			Fields: map[string]string{
+				"facility": w.facility,
+				"severity": w.severity,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Splunk "+w.token)
+
+	return postHTTP(w.client, w.url, "application/json", header, body.Bytes())
+}
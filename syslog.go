@@ -0,0 +1,471 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"maps"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatRFC3164 = "rfc3164"
+	formatRFC5424 = "rfc5424"
+
+	framingNewline    = "newline"
+	framingOctetCount = "octet-count"
+
+	// keepaliveTag is the tag of heartbeat messages sent over idle
+	// connections
+	keepaliveTag = "logpipe.keepalive"
+
+	// defaultSDID is used for structured data if no SD-ID is configured.
+	// 32473 is the private enterprise number reserved for documentation.
+	defaultSDID = "logpipe@32473"
+)
+
+// syslogFormatter formats syslog messages for a pipe.
+type syslogFormatter struct {
+	priority syslog.Priority
+	tag      string
+	hostname string
+	format   string
+	framing  string
+
+	// structuredData is the pre-formatted RFC 5424 structured data
+	structuredData string
+	sdid           string
+	params         map[string]string
+}
+
+func newSyslogFormatter(p pipe, priority syslog.Priority) syslogFormatter {
+	tag := p.Tag
+	if tag == "" {
+		tag = os.Args[0]
+	}
+
+	return syslogFormatter{
+		priority:       priority,
+		tag:            tag,
+		hostname:       p.hostname(),
+		format:         p.Format,
+		framing:        p.TCPFraming,
+		structuredData: formatStructuredData(p.SDID, p.StructuredData),
+		sdid:           p.SDID,
+		params:         p.StructuredData,
+	}
+}
+
+// formatMessage returns message as a syslog message without a trailing
+// newline. Fields are added to the structured data of RFC 5424 messages,
+// configured params take precedence.
+func (f syslogFormatter) formatMessage(message string, fields map[string]string) string {
+	message = strings.TrimSuffix(message, "\n")
+
+	if f.format == formatRFC5424 {
+		structuredData := f.structuredData
+		if len(fields) > 0 {
+			params := make(map[string]string, len(fields)+len(f.params))
+			for name, value := range fields {
+				if checkSDName(name) == nil {
+					params[name] = value
+				}
+			}
+			maps.Copy(params, f.params)
+			structuredData = formatStructuredData(f.sdid, params)
+		}
+
+		timestamp := time.Now().Format("2006-01-02T15:04:05.000000Z07:00")
+		return fmt.Sprintf("<%d>1 %s %s %s %d - %s %s", f.priority, timestamp, f.hostname, f.tag, os.Getpid(), structuredData, message)
+	}
+
+	timestamp := time.Now().Format(time.RFC3339)
+	return fmt.Sprintf("<%d>%s %s %s[%d]: %s", f.priority, timestamp, f.hostname, f.tag, os.Getpid(), message)
+}
+
+// frame frames a formatted message for sending. Messages are terminated by
+// a newline, or with octet counting prefixed by their length in bytes as
+// described in section 3.4.1 of RFC 6587.
+func (f syslogFormatter) frame(message string) string {
+	if f.framing == framingOctetCount {
+		return strconv.Itoa(len(message)) + " " + message
+	}
+
+	return message + "\n"
+}
+
+// connWriter writes syslog messages to a network connection. Unlike
+// log/syslog it works on any net.Conn, which allows us to use TLS, and it can
+// write RFC 5424 messages.
+type connWriter struct {
+	syslogFormatter
+	conn         net.Conn
+	writeTimeout time.Duration
+}
+
+func newConnWriter(conn net.Conn, p pipe, priority syslog.Priority) *connWriter {
+	return &connWriter{
+		syslogFormatter: newSyslogFormatter(p, priority),
+		conn:            conn,
+		writeTimeout:    p.WriteTimeout,
+	}
+}
+
+func (w *connWriter) Write(b []byte) (int, error) {
+	return w.WriteFields(b, nil)
+}
+
+// WriteFields writes a message. A write taking longer than the write
+// timeout fails, and the connection should not be used anymore.
+func (w *connWriter) WriteFields(b []byte, fields map[string]string) (int, error) {
+	if w.writeTimeout > 0 {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err := io.WriteString(w.conn, w.frame(w.formatMessage(string(b), fields)))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// keepalive writes a heartbeat message at debug severity. Only a write
+// can tell that a TCP connection was dropped by the other end or a
+// firewall along the way.
+func (w *connWriter) keepalive() error {
+	f := w.syslogFormatter
+	f.priority = w.priority&^0x07 | syslog.LOG_DEBUG
+	f.tag = keepaliveTag
+
+	if w.writeTimeout > 0 {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout)); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w.conn, f.frame(f.formatMessage("keepalive", nil)))
+	return err
+}
+
+func (w *connWriter) Close() error {
+	return w.conn.Close()
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// formatStructuredData formats params as a single RFC 5424 SD element. If
+// there are no params, the nil value "-" is returned.
+func formatStructuredData(sdid string, params map[string]string) string {
+	if len(params) == 0 {
+		return "-"
+	}
+
+	if sdid == "" {
+		sdid = defaultSDID
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("[" + sdid)
+	for _, name := range names {
+		fmt.Fprintf(&b, " %s=\"%s\"", name, sdEscaper.Replace(params[name]))
+	}
+	b.WriteString("]")
+
+	return b.String()
+}
+
+// checkSDName validates an SD-ID or a param name as described in section
+// 6.3 of RFC 5424.
+func checkSDName(name string) error {
+	if name == "" || len(name) > 32 {
+		return fmt.Errorf("%q must be between 1 and 32 characters", name)
+	}
+
+	for _, c := range name {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			return fmt.Errorf("%q contains invalid character %q", name, c)
+		}
+	}
+
+	return nil
+}
+
+// syslogSocket is the local syslog socket to use instead of the usual
+// paths. It's set when running in a chroot.
+var syslogSocket string
+
+// dialLocal connects to the local syslog socket.
+func dialLocal() (net.Conn, error) {
+	paths := []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+	if syslogSocket != "" {
+		paths = []string{syslogSocket}
+	}
+
+	return dialSocket(paths)
+}
+
+// dialSocket connects to the first of the syslog sockets at paths that
+// accepts the connection.
+func dialSocket(paths []string) (net.Conn, error) {
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range paths {
+			conn, err := net.Dial(network, path)
+			if err == nil {
+				return conn, nil
+			}
+		}
+	}
+
+	return nil, errors.New("unix syslog delivery error")
+}
+
+// dialSyslog opens a connection to the syslog configured for a pipe. If no
+// network is configured, the local syslog socket is used.
+func dialSyslog(p pipe, priority syslog.Priority, tlsConfig *tls.Config) (io.WriteCloser, error) {
+	// log/syslog is only used for the local socket. It only knows the
+	// usual socket paths, leaves out the hostname and can't limit the time
+	// of writes, so we use our own writer if any of these are configured.
+	// Remote syslogs always use our own writer, as log/syslog can't limit
+	// the time to connect either
+	if len(p.SyslogSockets) > 0 && p.Network == "" {
+		return dialSockets(p, priority)
+	}
+
+	if p.Network == "" && p.Format != formatRFC5424 && syslogSocket == "" && p.Hostname == "" && p.WriteTimeout == 0 {
+		writer, err := syslog.New(priority, p.Tag)
+		if err != nil {
+			return nil, err
+		}
+
+		return writer, nil
+	}
+
+	dial := func() (net.Conn, error) {
+		switch {
+		case tlsConfig != nil:
+			return tls.DialWithDialer(p.dialer(), p.Network, p.Address, tlsConfig)
+		case p.Network == "":
+			return dialLocal()
+		}
+
+		return p.dialer().Dial(p.Network, p.Address)
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.batchesSyslog() {
+		return newSyslogBatchWriter(conn, dial, p, priority), nil
+	}
+
+	return newConnWriter(conn, p, priority), nil
+}
+
+// batchesSyslog returns true if the pipe sends syslog messages over TCP in
+// batches.
+func (p pipe) batchesSyslog() bool {
+	isSyslog := p.Output == "" || p.Output == outputSyslog
+	return isSyslog && p.Network == "tcp" && (p.BatchSize > 0 || p.BatchTimeout > 0)
+}
+
+// syslogBatchWriter sends syslog messages over TCP in batches, each written
+// to the connection at once. A failing batch is retried on a new
+// connection.
+type syslogBatchWriter struct {
+	*batcher
+	syslogFormatter
+
+	dial         func() (net.Conn, error)
+	conn         net.Conn
+	writeTimeout time.Duration
+}
+
+func newSyslogBatchWriter(conn net.Conn, dial func() (net.Conn, error), p pipe, priority syslog.Priority) *syslogBatchWriter {
+	w := &syslogBatchWriter{
+		syslogFormatter: newSyslogFormatter(p, priority),
+		dial:            dial,
+		conn:            conn,
+		writeTimeout:    p.WriteTimeout,
+	}
+	w.batcher = newBatcher(p.destination(), p.BatchSize, p.BatchTimeout, w.send, deadLetterDrop(p, priority))
+
+	return w
+}
+
+func (w *syslogBatchWriter) Write(b []byte) (int, error) {
+	return w.WriteFields(b, nil)
+}
+
+// WriteFields formats a message and queues it for the next batch.
+func (w *syslogBatchWriter) WriteFields(b []byte, fields map[string]string) (int, error) {
+	if _, err := w.batcher.Write([]byte(w.frame(w.formatMessage(string(b), fields)))); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// send writes a batch to the connection. It's only called from the
+// batcher goroutine.
+func (w *syslogBatchWriter) send(batch []batchEntry) error {
+	if w.conn == nil {
+		conn, err := w.dial()
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+
+	var buf strings.Builder
+	for _, entry := range batch {
+		buf.WriteString(entry.message)
+	}
+
+	if w.writeTimeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	}
+
+	if _, err := io.WriteString(w.conn, buf.String()); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+// Close sends the queued messages and closes the connection.
+func (w *syslogBatchWriter) Close() error {
+	w.batcher.Close()
+
+	if w.conn != nil {
+		return w.conn.Close()
+	}
+
+	return nil
+}
+
+// socketsWriter writes each message to several local syslog sockets. A
+// failing socket is re-dialed on the next message, and doesn't hold back
+// the others.
+type socketsWriter struct {
+	syslogFormatter
+	paths   []string
+	conns   []net.Conn
+	failing []bool
+}
+
+// dialSockets connects to the syslog_sockets of a pipe. It fails only if
+// no socket can be connected to.
+func dialSockets(p pipe, priority syslog.Priority) (*socketsWriter, error) {
+	w := &socketsWriter{
+		syslogFormatter: newSyslogFormatter(p, priority),
+		paths:           p.SyslogSockets,
+		conns:           make([]net.Conn, len(p.SyslogSockets)),
+		failing:         make([]bool, len(p.SyslogSockets)),
+	}
+
+	var errs []error
+	for i, path := range w.paths {
+		conn, err := dialSocket([]string{path})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			w.failing[i] = true
+			continue
+		}
+		w.conns[i] = conn
+	}
+
+	if len(errs) == len(w.paths) {
+		return nil, errors.Join(errs...)
+	}
+	for _, err := range errs {
+		logWarning("Connecting to syslog socket failed: %s", err.Error())
+	}
+
+	return w, nil
+}
+
+func (w *socketsWriter) Write(b []byte) (int, error) {
+	return w.WriteFields(b, nil)
+}
+
+// WriteFields writes a message to all sockets. It fails only if writing
+// to every socket failed.
+func (w *socketsWriter) WriteFields(b []byte, fields map[string]string) (int, error) {
+	message := w.frame(w.formatMessage(string(b), fields))
+
+	var errs []error
+	for i, path := range w.paths {
+		err := w.writeSocket(i, message)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+
+		// Only changes are logged, not every message to a failing
+		// socket
+		switch {
+		case err != nil && !w.failing[i]:
+			logWarning("Writing to syslog socket %s failed: %s", path, err.Error())
+		case err == nil && w.failing[i]:
+			logInfo("Writing to syslog socket %s works again", path)
+		}
+		w.failing[i] = err != nil
+	}
+
+	if len(errs) == len(w.paths) {
+		return 0, errors.Join(errs...)
+	}
+
+	return len(b), nil
+}
+
+// writeSocket writes a message to one socket, connecting first if the
+// socket failed before.
+func (w *socketsWriter) writeSocket(i int, message string) error {
+	if w.conns[i] == nil {
+		conn, err := dialSocket([]string{w.paths[i]})
+		if err != nil {
+			return err
+		}
+		w.conns[i] = conn
+	}
+
+	if _, err := io.WriteString(w.conns[i], message); err != nil {
+		w.conns[i].Close()
+		w.conns[i] = nil
+		return err
+	}
+
+	return nil
+}
+
+func (w *socketsWriter) Close() error {
+	var errs []error
+
+	for _, conn := range w.conns {
+		if conn != nil {
+			errs = append(errs, conn.Close())
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	// rfc3164Time and rfc5424Time match the timestamps of the formats
+	rfc3164Time = `\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(Z|[+-]\d\d:\d\d)`
+	rfc5424Time = `\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}(Z|[+-]\d\d:\d\d)`
+)
+
+func TestFormatMessage(t *testing.T) {
+	pid := os.Getpid()
+
+	tests := []struct {
+		name   string
+		pipe   pipe
+		fields map[string]string
+		want   string
+	}{
+		{
+			name: "rfc3164",
+			pipe: pipe{},
+			want: fmt.Sprintf(`<182>%s web01 app\[%d\]: started`, rfc3164Time, pid),
+		},
+		{
+			name: "rfc5424 without structured data",
+			pipe: pipe{Format: formatRFC5424},
+			want: fmt.Sprintf(`<182>1 %s web01 app %d - - started`, rfc5424Time, pid),
+		},
+		{
+			name: "rfc5424 with structured data",
+			pipe: pipe{
+				Format:         formatRFC5424,
+				SDID:           "app@32473",
+				StructuredData: map[string]string{"env": "production", "dc": "ams1"},
+			},
+			want: fmt.Sprintf(`<182>1 %s web01 app %d - \[app@32473 dc="ams1" env="production"\] started`, rfc5424Time, pid),
+		},
+		{
+			name:   "rfc5424 with fields",
+			pipe:   pipe{Format: formatRFC5424, StructuredData: map[string]string{"env": "production"}},
+			fields: map[string]string{"env": "from message", "user": "bob", "bad name": "dropped"},
+			want:   fmt.Sprintf(`<182>1 %s web01 app %d - \[logpipe@32473 env="production" user="bob"\] started`, rfc5424Time, pid),
+		},
+		{
+			name:   "rfc3164 ignores fields",
+			pipe:   pipe{},
+			fields: map[string]string{"user": "bob"},
+			want:   fmt.Sprintf(`<182>%s web01 app\[%d\]: started`, rfc3164Time, pid),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := test.pipe
+			p.Tag = "app"
+			p.Hostname = "web01"
+
+			f := newSyslogFormatter(p, syslog.LOG_LOCAL6|syslog.LOG_INFO)
+			message := f.formatMessage("started\n", test.fields)
+
+			if !regexp.MustCompile("^" + test.want + "$").MatchString(message) {
+				t.Errorf("message %q doesn't match %q", message, test.want)
+			}
+		})
+	}
+}
+
+func TestFormatStructuredData(t *testing.T) {
+	tests := []struct {
+		sdid   string
+		params map[string]string
+		want   string
+	}{
+		{"", nil, "-"},
+		{"", map[string]string{"a": "1"}, `[logpipe@32473 a="1"]`},
+		{"app@1", map[string]string{"b": "2", "a": "1"}, `[app@1 a="1" b="2"]`},
+
+		// Section 6.3.3 of RFC 5424: '"', '\' and ']' are escaped
+		{"app@1", map[string]string{"a": `say "hi" \o/ [x]`}, `[app@1 a="say \"hi\" \\o/ [x\]"]`},
+	}
+
+	for _, test := range tests {
+		if got := formatStructuredData(test.sdid, test.params); got != test.want {
+			t.Errorf("formatStructuredData(%q, %v) = %s, want %s", test.sdid, test.params, got, test.want)
+		}
+	}
+}
+
+func TestCheckSDName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"env", true},
+		{"logpipe@32473", true},
+		{"", false},
+		{"has space", false},
+		{"a=b", false},
+		{`quote"`, false},
+		{"bracket]", false},
+		{"ümlaut", false},
+		{"abcdefghijklmnopqrstuvwxyz012345", true},
+		{"abcdefghijklmnopqrstuvwxyz0123456", false},
+	}
+
+	for _, test := range tests {
+		if err := checkSDName(test.name); (err == nil) != test.valid {
+			t.Errorf("checkSDName(%q) = %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+// scanOctetCounted is a bufio.SplitFunc for syslog messages framed by
+// octet counting, as a receiver would read them.
+func scanOctetCounted(data []byte, atEOF bool) (int, []byte, error) {
+	length, rest, found := bytes.Cut(data, []byte(" "))
+	if !found {
+		if atEOF && len(data) > 0 {
+			return 0, nil, errors.New("incomplete frame")
+		}
+		return 0, nil, nil
+	}
+
+	size, err := strconv.Atoi(string(length))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(rest) < size {
+		if atEOF {
+			return 0, nil, errors.New("incomplete frame")
+		}
+		return 0, nil, nil
+	}
+
+	return len(length) + 1 + size, rest[:size], nil
+}
+
+func TestOctetCountingFraming(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	p := pipe{Tag: "app", Hostname: "web01", Network: "tcp", TCPFraming: framingOctetCount}
+	w := newConnWriter(client, p, syslog.LOG_LOCAL6|syslog.LOG_INFO)
+
+	// Lengths are in bytes, and messages may hold newlines and spaces
+	messages := []string{"started", "größe 10 MB", "first line\nsecond line", "10 20 30"}
+
+	go func() {
+		for _, message := range messages {
+			if _, err := w.Write([]byte(message + "\n")); err != nil {
+				t.Error(err)
+			}
+		}
+		w.Close()
+	}()
+
+	scanner := bufio.NewScanner(server)
+	scanner.Split(scanOctetCounted)
+
+	for _, message := range messages {
+		if !scanner.Scan() {
+			t.Fatalf("no frame for %q: %v", message, scanner.Err())
+		}
+
+		suffix := fmt.Sprintf(" web01 app[%d]: %s", os.Getpid(), message)
+		if frame := scanner.Text(); !strings.HasPrefix(frame, "<182>") || !strings.HasSuffix(frame, suffix) {
+			t.Errorf("frame %q, want message %q", frame, message)
+		}
+	}
+
+	if scanner.Scan() {
+		t.Errorf("unexpected frame %q", scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	tests := []struct {
+		framing string
+		message string
+		want    string
+	}{
+		{"", "<182>hello", "<182>hello\n"},
+		{framingNewline, "<182>hello", "<182>hello\n"},
+		{framingOctetCount, "<182>hello", "10 <182>hello"},
+		{framingOctetCount, "<182>größe", "12 <182>größe"},
+	}
+
+	for _, test := range tests {
+		f := syslogFormatter{framing: test.framing}
+		if got := f.frame(test.message); got != test.want {
+			t.Errorf("frame(%q) with %q = %q, want %q", test.message, test.framing, got, test.want)
+		}
+	}
+}
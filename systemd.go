@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// notify sends a state change to systemd. It does nothing unless logpipe
+// is run by systemd as a Type=notify service.
+func notify(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		logWarning("Notifying systemd failed: %s", err.Error())
+	}
+}
+
+// startWatchdog pings the systemd watchdog at half the interval set by
+// WatchdogSec in the unit file. It does nothing if the watchdog is not
+// enabled.
+func startWatchdog() {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		logWarning("Reading systemd watchdog interval failed: %s", err.Error())
+		return
+	}
+
+	if interval == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			notify(daemon.SdNotifyWatchdog)
+		}
+	}()
+}
+
+// loadActivated reads the file descriptors passed by systemd, for example
+// with ListenFIFO= in a socket unit. Each is matched to a pipe by the path
+// it was opened from.
+func loadActivated() {
+	for _, f := range activation.Files(true) {
+		path, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", f.Fd()))
+		if err != nil {
+			logWarning("Resolving activated file descriptor %s failed: %s", f.Name(), err.Error())
+			f.Close()
+			continue
+		}
+
+		// systemd passes blocking descriptors. Read deadlines need a
+		// non-blocking descriptor, which os.NewFile only gives us for
+		// descriptors that are non-blocking to begin with
+		fd, err := syscall.Dup(int(f.Fd()))
+		f.Close()
+		if err == nil {
+			err = syscall.SetNonblock(fd, true)
+			if err != nil {
+				syscall.Close(fd)
+			}
+		}
+		if err != nil {
+			logWarning("Using activated file descriptor for %s failed: %s", path, err.Error())
+			continue
+		}
+
+		syscall.CloseOnExec(fd)
+		preopened[filepath.Clean(path)] = os.NewFile(uintptr(fd), path)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// tagContext is the data available to tag templates.
+type tagContext struct {
+	Hostname string
+	Tag      string
+	PipePath string
+	PipeBase string
+}
+
+// renderTag returns the tag of a pipe, rendered from tag_template if set.
+func renderTag(p pipe) (string, error) {
+	if p.TagTemplate == "" {
+		return p.Tag, nil
+	}
+
+	tmpl, err := template.New("tag").Parse(p.TagTemplate)
+	if err != nil {
+		return "", fmt.Errorf("%s has invalid tag_template: %w", p.source(), err)
+	}
+
+	var tag strings.Builder
+	err = tmpl.Execute(&tag, tagContext{
+		Hostname: p.hostname(),
+		Tag:      p.Tag,
+		PipePath: p.source(),
+		PipeBase: filepath.Base(p.source()),
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s has invalid tag_template: %w", p.source(), err)
+	}
+
+	return tag.String(), nil
+}
+
+// renderTags replaces the tags of all pipes and their outputs with their
+// rendered tag_template. Templates are rendered once, when the
+// configuration is read.
+func renderTags(config *config) error {
+	for i, p := range config.Pipe {
+		for j, output := range p.Outputs {
+			tag, err := renderTag(output)
+			if err != nil {
+				return err
+			}
+			p.Outputs[j].Tag = tag
+		}
+
+		tag, err := renderTag(p)
+		if err != nil {
+			return err
+		}
+		config.Pipe[i].Tag = tag
+	}
+
+	return nil
+}
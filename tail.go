@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// defaultTailInterval is how often a tailed file is checked for new lines
+// unless configured otherwise.
+const defaultTailInterval = 250 * time.Millisecond
+
+// tailer follows a file like tail -F.
+type tailer struct {
+	path   string
+	fd     *os.File
+	reader *bufio.Reader
+	offset int64
+
+	// partial holds a line that hasn't been terminated yet
+	partial string
+}
+
+// open opens the file. If end is true, reading starts at the end of the
+// file.
+func (t *tailer) open(end bool) error {
+	fd, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+
+	t.offset = 0
+	if end {
+		t.offset, err = fd.Seek(0, io.SeekEnd)
+		if err != nil {
+			fd.Close()
+			return err
+		}
+	}
+
+	t.fd = fd
+	t.reader = bufio.NewReader(fd)
+	t.partial = ""
+
+	return nil
+}
+
+// read passes all complete lines available to handle.
+func (t *tailer) read(handle func(string)) error {
+	for {
+		line, err := t.reader.ReadString(0xa)
+		t.offset += int64(len(line))
+
+		if err == io.EOF {
+			t.partial += line
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		handle(t.partial + line)
+		t.partial = ""
+	}
+}
+
+// rotated returns true if the file at path was replaced or truncated.
+func (t *tailer) rotated() (replaced bool, truncated bool) {
+	current, err := t.fd.Stat()
+	if err != nil {
+		return false, false
+	}
+
+	// If the file is missing, it's probably being rotated. We'll look
+	// again next time
+	fileInfo, err := os.Stat(t.path)
+	if err != nil {
+		return false, false
+	}
+
+	if !os.SameFile(current, fileInfo) {
+		return true, false
+	}
+
+	return false, fileInfo.Size() < t.offset
+}
+
+// tailFile passes lines appended to the file at path to handle until ctx is
+// cancelled. When the file is replaced or truncated, it's read again from
+// the beginning.
+func tailFile(ctx context.Context, path string, interval time.Duration, handle func(string)) error {
+	t := &tailer{path: path}
+	if err := t.open(true); err != nil {
+		return err
+	}
+	defer func() {
+		t.fd.Close()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := t.read(handle); err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			if t.partial != "" {
+				handle(t.partial)
+			}
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			continue
+		case <-ticker.C:
+		}
+
+		replaced, truncated := t.rotated()
+		switch {
+		case replaced:
+			// Read what was written to the old file before it was
+			// replaced
+			if err := t.read(handle); err != nil {
+				return err
+			}
+			if t.partial != "" {
+				handle(t.partial)
+			}
+
+			t.fd.Close()
+			if err := t.open(false); err != nil {
+				return err
+			}
+
+		case truncated:
+			if _, err := t.fd.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+
+			t.offset = 0
+			t.reader.Reset(t.fd)
+			t.partial = ""
+		}
+	}
+}
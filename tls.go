@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// usesTLS returns true if any TLS setting is configured for the pipe.
+func (p pipe) usesTLS() bool {
+	return p.TLSCert != "" || p.TLSKey != "" || p.TLSCA != "" || p.InsecureSkipVerify
+}
+
+// tlsConfig builds a TLS configuration from the certificate paths configured
+// for a pipe.
+func tlsConfig(p pipe) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: p.InsecureSkipVerify,
+	}
+
+	if p.TLSCert != "" || p.TLSKey != "" {
+		if p.TLSCert == "" || p.TLSKey == "" {
+			return nil, errors.New("tls_cert and tls_key must be set together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(p.TLSCert, p.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate %s failed: %s", p.TLSCert, err.Error())
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if p.TLSCA != "" {
+		pem, err := os.ReadFile(p.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA %s failed: %s", p.TLSCA, err.Error())
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA %s", p.TLSCA)
+		}
+
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// Modes for syscall.Access
+const (
+	accessRead  = 4
+	accessWrite = 2
+	accessExec  = 1
+)
+
+// checkAccess returns an error if path exists but can't be accessed with
+// mode, or if it doesn't exist and its directory isn't writable.
+func checkAccess(path string, mode uint32) error {
+	if _, err := os.Stat(path); err == nil {
+		if err := syscall.Access(path, mode); err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	if err := syscall.Access(dir, accessWrite|accessExec); err != nil {
+		return fmt.Errorf("%s can't be created in %s: %s", path, dir, err.Error())
+	}
+
+	return nil
+}
+
+// checkPaths checks that the files used by a pipe can be accessed.
+func checkPaths(p pipe) []error {
+	var errs []error
+
+	switch {
+	case p.Path != "" && p.Path != stdinPath:
+		if fileInfo, err := os.Stat(p.Path); err == nil && fileInfo.Mode()&os.ModeNamedPipe == 0 {
+			errs = append(errs, fmt.Errorf("%s exists, but it's not a named pipe (FIFO)", p.Path))
+		} else if err := checkAccess(p.Path, accessRead); err != nil {
+			errs = append(errs, err)
+		}
+
+	case p.ListenUnix != "":
+		if err := checkAccess(p.ListenUnix, accessWrite); err != nil {
+			errs = append(errs, err)
+		}
+
+	case p.TailFile != "":
+		if err := syscall.Access(p.TailFile, accessRead); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", p.TailFile, err.Error()))
+		}
+
+	case p.Exec != "":
+		if args := strings.Fields(p.Exec); len(args) > 0 {
+			if _, err := exec.LookPath(args[0]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkChroot checks that the files pipes need after chrooting exist inside
+// the chroot. Named pipes are opened before chrooting and don't need to.
+func checkChroot(config config) []error {
+	root := config.Security.Chroot
+	if root == "" {
+		return nil
+	}
+
+	if fileInfo, err := os.Stat(root); err != nil || !fileInfo.IsDir() {
+		return []error{fmt.Errorf("chroot %s is not a directory", root)}
+	}
+
+	socket := config.Security.SyslogSocket
+	if socket == "" {
+		socket = defaultSyslogSocket
+	}
+
+	// need adds an error if path doesn't exist inside the chroot
+	var errs []error
+	need := func(path, why string) {
+		if path == "" || path == stdinPath {
+			return
+		}
+
+		if _, err := os.Stat(filepath.Join(root, path)); err != nil {
+			errs = append(errs, fmt.Errorf("%s is needed inside chroot %s for %s", path, root, why))
+		}
+	}
+
+	pipes := slices.Clone(config.Pipe)
+	for _, p := range config.Pipe {
+		pipes = append(pipes, p.Outputs...)
+	}
+
+	usesHostnames := false
+	for _, p := range pipes {
+		switch {
+		case len(p.Outputs) > 0:
+			// The outputs are checked on their own
+		case p.Output == outputJSON:
+			need(filepath.Dir(p.OutputPath), "output_path")
+		case (p.Output == "" || p.Output == outputSyslog) && p.Network == "":
+			need(socket, "logging to the local syslog")
+		default:
+			usesHostnames = true
+		}
+
+		need(p.TLSCA, "tls_ca")
+		need(p.TLSCert, "tls_cert")
+		need(p.TLSKey, "tls_key")
+		need(p.TailFile, "tail_file")
+	}
+
+	if usesHostnames {
+		need("/etc/resolv.conf", "resolving host names")
+		need("/etc/hosts", "resolving host names")
+	}
+
+	if config.Logging.Output == loggingFile {
+		need(filepath.Dir(config.Logging.File), "the log file when reloading")
+	}
+
+	need(*configPath, "reloading the configuration")
+
+	return errs
+}
+
+// validateConfig checks the configuration file without creating any pipes
+// or connecting to syslog. It prints all errors found and returns the exit
+// code.
+func validateConfig(path string) int {
+	config, undecoded, err := decodeConfig(path)
+	if err != nil {
+		fmt.Printf("Configuration error: %s\n", err.Error())
+		return exitConfigError
+	}
+
+	errs := checkConfig(config)
+	for _, key := range undecoded {
+		errs = append(errs, fmt.Errorf("unknown key %s", key))
+	}
+
+	for _, p := range config.Pipe {
+		errs = append(errs, checkPaths(p)...)
+	}
+	errs = append(errs, checkChroot(config)...)
+
+	for _, err := range errs {
+		fmt.Printf("Configuration error: %s\n", err.Error())
+	}
+
+	if len(errs) > 0 {
+		fmt.Printf("%s: %d errors found\n", path, len(errs))
+		return exitConfigError
+	}
+
+	fmt.Printf("%s: %d pipes, configuration OK\n", path, len(config.Pipe))
+
+	return exitOK
+}
@@ -0,0 +1,61 @@
+package main
+
+import "strings"
+
+// w3cParser extracts fields from lines in the W3C Extended Log Format,
+// named by the last #Fields directive. The fields are forgotten when the
+// writer closes the pipe, as the next file may declare others.
+type w3cParser struct {
+	fields []string
+}
+
+// newW3CParser returns the parser of a pipe, or nil if parse_w3c_elf is not
+// set.
+func newW3CParser(p pipe) *w3cParser {
+	if !p.ParseW3CELF {
+		return nil
+	}
+
+	return &w3cParser{}
+}
+
+// directive returns true if line is a directive like #Fields or #Version,
+// which is not forwarded. A #Fields directive sets the field names.
+func (w *w3cParser) directive(line string) bool {
+	if w == nil || !strings.HasPrefix(line, "#") {
+		return false
+	}
+
+	if names, ok := strings.CutPrefix(line, "#Fields:"); ok {
+		w.fields = strings.Fields(names)
+	}
+
+	return true
+}
+
+// parse returns the fields of a line. It returns false before the first
+// #Fields directive, and for lines not matching it.
+func (w *w3cParser) parse(line string) (map[string]string, bool) {
+	if w == nil || len(w.fields) == 0 {
+		return nil, false
+	}
+
+	values := strings.Fields(line)
+	if len(values) != len(w.fields) {
+		return nil, false
+	}
+
+	fields := make(map[string]string, len(values))
+	for i, name := range w.fields {
+		fields[name] = values[i]
+	}
+
+	return fields, true
+}
+
+// reset forgets the field names.
+func (w *w3cParser) reset() {
+	if w != nil {
+		w.fields = nil
+	}
+}
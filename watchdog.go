@@ -0,0 +1,62 @@
+package main
+
+import "time"
+
+// watchdog reports pipes that didn't receive a line for a while. Lines are
+// signalled through a channel, so only the goroutine in run touches the
+// timer.
+type watchdog struct {
+	timeout time.Duration
+	lines   chan struct{}
+}
+
+// newWatchdog returns the watchdog of a pipe, or nil if watchdog_timeout is
+// not set.
+func newWatchdog(p pipe) *watchdog {
+	if p.WatchdogTimeout <= 0 {
+		return nil
+	}
+
+	return &watchdog{
+		timeout: p.WatchdogTimeout,
+		lines:   make(chan struct{}, 1),
+	}
+}
+
+// seen signals that a line was received. It never blocks: if a signal is
+// already pending, the watchdog hasn't seen it yet and this one is not
+// needed.
+func (w *watchdog) seen() {
+	if w == nil {
+		return
+	}
+
+	select {
+	case w.lines <- struct{}{}:
+	default:
+	}
+}
+
+// run calls warn with the time since the last line each time timeout passes
+// without one, until stop is closed.
+func (w *watchdog) run(stop <-chan struct{}, warn func(time.Duration)) {
+	last := time.Now()
+
+	timer := time.NewTimer(w.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+
+		case <-w.lines:
+			last = time.Now()
+			timer.Reset(w.timeout)
+
+		case now := <-timer.C:
+			warn(now.Sub(last))
+			timer.Reset(w.timeout)
+		}
+	}
+}
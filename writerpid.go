@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultWriterCheckInterval is how often the writer of a pipe is checked
+// unless configured otherwise.
+const defaultWriterCheckInterval = 30 * time.Second
+
+// checkWriter returns an error if the process in pidfile is not running.
+func checkWriter(pidfile string) error {
+	data, err := os.ReadFile(pidfile)
+	if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("%s has no valid PID", pidfile)
+	}
+
+	// On Unix FindProcess always succeeds, signal 0 tells if the process
+	// exists. A process we may not signal exists too
+	process, _ := os.FindProcess(pid)
+	err = process.Signal(syscall.Signal(0))
+	if err != nil && !errors.Is(err, syscall.EPERM) {
+		return fmt.Errorf("process %d from %s is not running", pid, pidfile)
+	}
+
+	return nil
+}
+
+// watchWriter checks the writer of a pipe every interval until stop is
+// closed. warn is called when the writer is found gone, and again only
+// after it was seen running in between.
+func watchWriter(stop <-chan struct{}, p pipe, warn func(err error)) {
+	interval := p.WriterCheckInterval
+	if interval <= 0 {
+		interval = defaultWriterCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	gone := false
+	for {
+		err := checkWriter(p.WriterPidfile)
+		switch {
+		case err != nil && !gone:
+			warn(err)
+		case err == nil && gone:
+			logInfo("Writer of %s is running again", p.source())
+		}
+		gone = err != nil
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}